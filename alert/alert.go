@@ -0,0 +1,362 @@
+// Package alert fires webhooks when lines drained for a token match rules,
+// such as Heroku error codes logged more than a threshold within a window.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/heroku/log-boom/syslog"
+)
+
+const (
+	// MaxSampleLines is the most matching lines included in an alert.
+	MaxSampleLines = 10
+
+	// QueueSize is the number of alerts which may wait to be delivered
+	// before further alerts are dropped.
+	QueueSize = 256
+
+	// Workers is the number of alerts delivered concurrently.
+	Workers = 4
+
+	// DefaultMaxAttempts is the default number of times delivery of an
+	// alert to a webhook is attempted.
+	DefaultMaxAttempts = 5
+
+	// DefaultBackoff is the default wait before the first retry, doubled
+	// after every further attempt.
+	DefaultBackoff = time.Second
+
+	// DefaultTimeout bounds each attempt to deliver an alert when no Client
+	// is set.
+	DefaultTimeout = 10 * time.Second
+
+	// maxSeen bounds the lines remembered per rule and token for dedup.
+	maxSeen = 10000
+)
+
+// Alert is the JSON payload POSTed to webhooks. ID is the same for every
+// attempt to deliver an alert, so receivers can drop retried deliveries.
+type Alert struct {
+	ID      string         `json:"id"`
+	Rule    string         `json:"rule"`
+	Token   string         `json:"token"`
+	Count   int            `json:"count"`
+	Window  string         `json:"window"`
+	FiredAt time.Time      `json:"fired_at"`
+	Codes   map[string]int `json:"codes,omitempty"`
+	Lines   []string       `json:"lines"`
+}
+
+// Alerter matches drained lines against rules and delivers alerts in the
+// background. It is safe for concurrent use.
+type Alerter struct {
+	// Client is used to POST alerts. If nil, a client with DefaultTimeout
+	// is used.
+	Client *http.Client
+
+	// MaxAttempts and Backoff control retries of failed deliveries.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	rules []Rule
+	now   func() time.Time
+
+	mu     sync.Mutex
+	state  map[stateKey]*window
+	closed bool
+
+	queue chan delivery
+	wg    sync.WaitGroup
+
+	// ctx is cancelled when Close gives up waiting, aborting deliveries.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// defaultClient is used when an Alerter has no Client.
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+type stateKey struct {
+	rule  int
+	token string
+}
+
+// window holds the recent matches of a rule for a token.
+type window struct {
+	hits       []hit
+	seen       map[string]time.Time
+	quietUntil time.Time
+}
+
+type hit struct {
+	at   time.Time
+	line string
+	code string
+}
+
+type delivery struct {
+	alert *Alert
+	url   string
+}
+
+// New creates an Alerter for rules and starts delivering its alerts.
+func New(rules []Rule) *Alerter {
+	a := &Alerter{
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		rules:       rules,
+		now:         time.Now,
+		state:       make(map[stateKey]*window),
+		queue:       make(chan delivery, QueueSize),
+	}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < Workers; i++ {
+		a.wg.Add(1)
+		go a.work()
+	}
+	return a
+}
+
+// Observe matches lines drained for token against the rules, queueing an
+// alert for every rule which fires. It does not block on delivery and does
+// not retain lines.
+func (a *Alerter) Observe(token string, lines []string) {
+	var applicable []int
+	for i := range a.rules {
+		if a.rules[i].appliesTo(token) {
+			applicable = append(applicable, i)
+		}
+	}
+	if len(applicable) == 0 {
+		return
+	}
+
+	for _, line := range lines {
+		m, err := syslog.Parse([]byte(line))
+		if err != nil {
+			continue
+		}
+		for _, i := range applicable {
+			code, ok := a.rules[i].match(m)
+			if !ok {
+				continue
+			}
+			if alert := a.record(i, token, line, code); alert != nil {
+				a.enqueue(i, alert)
+			}
+		}
+	}
+}
+
+// record adds a match of rule i for token, returning an alert if the rule
+// fires. Lines already seen within the window are ignored, as Logplex may
+// deliver a batch more than once.
+func (a *Alerter) record(i int, token, line, code string) *Alert {
+	r := &a.rules[i]
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := stateKey{rule: i, token: token}
+	w, ok := a.state[key]
+	if !ok {
+		w = &window{seen: make(map[string]time.Time)}
+		a.state[key] = w
+	}
+	w.prune(now.Add(-r.Window))
+
+	if _, dup := w.seen[line]; dup {
+		return nil
+	}
+	if len(w.seen) < maxSeen {
+		w.seen[line] = now
+	}
+	w.hits = append(w.hits, hit{at: now, line: line, code: code})
+
+	if len(w.hits) < r.Threshold || now.Before(w.quietUntil) {
+		return nil
+	}
+
+	alert := &Alert{
+		ID:      newID(),
+		Rule:    r.Name,
+		Token:   token,
+		Count:   len(w.hits),
+		Window:  r.Window.String(),
+		FiredAt: now.UTC(),
+		Codes:   make(map[string]int),
+	}
+	for _, h := range w.hits {
+		if h.code != "" {
+			alert.Codes[h.code]++
+		}
+	}
+	samples := w.hits
+	if len(samples) > MaxSampleLines {
+		samples = samples[len(samples)-MaxSampleLines:]
+	}
+	for _, h := range samples {
+		alert.Lines = append(alert.Lines, h.line)
+	}
+
+	w.hits = nil
+	w.quietUntil = now.Add(r.Cooldown)
+	return alert
+}
+
+// prune forgets matches from before cutoff.
+func (w *window) prune(cutoff time.Time) {
+	i := 0
+	for i < len(w.hits) && w.hits[i].at.Before(cutoff) {
+		i++
+	}
+	w.hits = w.hits[i:]
+
+	for line, at := range w.seen {
+		if at.Before(cutoff) {
+			delete(w.seen, line)
+		}
+	}
+}
+
+// enqueue queues alert for delivery to the webhooks of rule i, dropping it
+// if the queue is full.
+func (a *Alerter) enqueue(i int, alert *Alert) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+
+	for _, url := range a.rules[i].Webhooks {
+		select {
+		case a.queue <- delivery{alert: alert, url: url}:
+		default:
+			log.WithFields(log.Fields{
+				"at":    "alert",
+				"rule":  alert.Rule,
+				"token": alert.Token,
+			}).Error("alert queue full, dropping alert")
+		}
+	}
+}
+
+func (a *Alerter) work() {
+	defer a.wg.Done()
+	for d := range a.queue {
+		if a.ctx.Err() != nil {
+			continue
+		}
+		a.deliver(d)
+	}
+}
+
+// deliver POSTs an alert to a webhook, retrying with exponential backoff on
+// network errors, 429s and 5xxs.
+func (a *Alerter) deliver(d delivery) {
+	body, err := json.Marshal(d.alert)
+	if err != nil {
+		return
+	}
+
+	backoff := a.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := a.post(d.url, d.alert.ID, body)
+		if err == nil || a.ctx.Err() != nil {
+			return
+		}
+		if !retry || attempt >= a.MaxAttempts {
+			log.WithFields(log.Fields{
+				"at":       "alert",
+				"rule":     d.alert.Rule,
+				"attempts": attempt,
+				"err":      err,
+			}).Error("unable to deliver alert")
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-a.ctx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post sends body to url, reporting whether a failure is worth retrying.
+func (a *Alerter) post(url, id string, body []byte) (bool, error) {
+	client := a.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(a.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "log-boom")
+	req.Header.Set("Idempotency-Key", id)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == 429 || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// Close stops accepting alerts and waits for those queued to be delivered,
+// including their retries, until ctx is done. Deliveries still pending then
+// are abandoned, and ctx's error is returned.
+func (a *Alerter) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	delivered := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+		a.cancel()
+		return nil
+	case <-ctx.Done():
+		a.cancel()
+		<-delivered
+		return ctx.Err()
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
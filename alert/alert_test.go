@@ -0,0 +1,175 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook is a local stand-in for an alert receiver, failing the first
+// failures requests.
+type webhook struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	attempts []string
+	alerts   []Alert
+}
+
+func newWebhook(failures int) *webhook {
+	h := &webhook{failures: failures}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.attempts = append(h.attempts, r.Header.Get("Idempotency-Key"))
+		if len(h.attempts) <= h.failures {
+			http.Error(w, http.StatusText(503), 503)
+			return
+		}
+		var a Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		h.alerts = append(h.alerts, a)
+	}))
+	return h
+}
+
+// received returns copies of the Idempotency-Keys of every request and the
+// alerts accepted, in the order they arrived.
+func (h *webhook) received() ([]string, []Alert) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.attempts...), append([]Alert(nil), h.alerts...)
+}
+
+func r14(n int) string {
+	return fmt.Sprintf("<172>1 2026-10-17T00:00:00+00:00 host heroku web.1 - Error R14 (Memory quota exceeded) %d", n)
+}
+
+func newTestAlerter(rule Rule) (*Alerter, *time.Time) {
+	if err := rule.validate(); err != nil {
+		panic(err)
+	}
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	a := New([]Rule{rule})
+	a.Backoff = time.Millisecond
+	a.now = func() time.Time { return now }
+	return a, &now
+}
+
+func TestRetry(t *testing.T) {
+	hook := newWebhook(2)
+	defer hook.Close()
+
+	a, _ := newTestAlerter(Rule{Name: "r14", Codes: []string{"R14"}, Webhooks: []string{hook.URL}})
+	a.Observe("t.1", []string{r14(1)})
+	if err := a.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	attempts, alerts := hook.received()
+	if len(attempts) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(attempts))
+	}
+	for _, id := range attempts {
+		if id == "" || id != attempts[0] {
+			t.Errorf("Idempotency-Key = %q, want %q on every attempt", id, attempts[0])
+		}
+	}
+	if len(alerts) != 1 || alerts[0].ID != attempts[0] || alerts[0].Codes["R14"] != 1 {
+		t.Errorf("alerts = %+v", alerts)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	hook := newWebhook(100)
+	defer hook.Close()
+
+	a, _ := newTestAlerter(Rule{Name: "r14", Codes: []string{"R14"}, Webhooks: []string{hook.URL}})
+	a.MaxAttempts = 3
+	a.Observe("t.1", []string{r14(1)})
+	a.Close(context.Background())
+
+	if attempts, alerts := hook.received(); len(attempts) != 3 || len(alerts) != 0 {
+		t.Errorf("attempts = %d, alerts = %d, want 3, 0", len(attempts), len(alerts))
+	}
+}
+
+func TestDedup(t *testing.T) {
+	hook := newWebhook(0)
+	defer hook.Close()
+
+	a, _ := newTestAlerter(Rule{Name: "r14", Codes: []string{"R14"}, Threshold: 2, Webhooks: []string{hook.URL}})
+	a.Observe("t.1", []string{r14(1)})
+	a.Observe("t.1", []string{r14(1)}) // redelivered, not a second match
+	a.Observe("t.2", []string{r14(2)}) // another token's window
+	a.Observe("t.1", []string{r14(3)})
+	a.Close(context.Background())
+
+	_, alerts := hook.received()
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %d, want 1", len(alerts))
+	}
+	if got := alerts[0]; got.Token != "t.1" || got.Count != 2 || len(got.Lines) != 2 {
+		t.Errorf("alert = %+v, want 2 lines of t.1", got)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	hook := newWebhook(0)
+	defer hook.Close()
+
+	a, now := newTestAlerter(Rule{Name: "r14", Codes: []string{"R14"}, Cooldown: 10 * time.Minute, Webhooks: []string{hook.URL}})
+	a.Observe("t.1", []string{r14(1)})
+	*now = now.Add(5 * time.Minute)
+	a.Observe("t.1", []string{r14(2)})
+	*now = now.Add(6 * time.Minute)
+	a.Observe("t.1", []string{r14(3)})
+	a.Close(context.Background())
+
+	// Alerts are delivered concurrently, so may arrive in either order.
+	_, alerts := hook.received()
+	if len(alerts) != 2 {
+		t.Fatalf("alerts = %d, want 2", len(alerts))
+	}
+	if alerts[0].FiredAt.After(alerts[1].FiredAt) {
+		alerts[0], alerts[1] = alerts[1], alerts[0]
+	}
+	if lines := alerts[0].Lines; len(lines) != 1 || lines[0] != r14(1) {
+		t.Errorf("first alert lines = %q, want only the first line", lines)
+	}
+	if lines := alerts[1].Lines; len(lines) != 1 || lines[0] != r14(3) {
+		t.Errorf("second alert lines = %q, want only the line after the cooldown", lines)
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	a, _ := newTestAlerter(Rule{Name: "r14", Codes: []string{"R14"}, Webhooks: []string{hung.URL}})
+	a.Observe("t.1", []string{r14(1)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := a.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Close = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v, want it to give up at the deadline", elapsed)
+	}
+}
@@ -0,0 +1,194 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/heroku/log-boom/syslog"
+)
+
+// Rule validation errors
+var (
+	ErrNoName     = errors.New("rule has no name")
+	ErrNoMatch    = errors.New("rule has neither codes nor a regexp")
+	ErrNoWebhooks = errors.New("rule has no webhooks")
+)
+
+// DefaultWindow is the sliding window of rules with a threshold but no window.
+const DefaultWindow = time.Minute
+
+// Rule fires an alert when Threshold lines of a drain match it within Window.
+type Rule struct {
+	Name string
+
+	// Tokens restricts the rule to these drain tokens, every token if empty.
+	Tokens []string
+
+	// Codes are Heroku error codes, eg "H12" or "R14". A line matches if it
+	// carries any of them, or if Codes is empty.
+	Codes []string
+
+	// Regexp, if set, must also match the message body of a line.
+	Regexp *regexp.Regexp
+
+	// Threshold is the number of matching lines within Window which fire
+	// the rule, at least 1.
+	Threshold int
+	Window    time.Duration
+
+	// Cooldown is how long after firing for a token the rule stays quiet
+	// for that token.
+	Cooldown time.Duration
+
+	// Webhooks are the URLs alerts are POSTed to.
+	Webhooks []string
+}
+
+// ruleJSON is the JSON form of a Rule, with durations such as "5m".
+type ruleJSON struct {
+	Name      string   `json:"name"`
+	Tokens    []string `json:"tokens"`
+	Codes     []string `json:"codes"`
+	Regexp    string   `json:"regexp"`
+	Threshold int      `json:"threshold"`
+	Window    string   `json:"window"`
+	Cooldown  string   `json:"cooldown"`
+	Webhooks  []string `json:"webhooks"`
+}
+
+// ParseRules parses a JSON array of rules, eg
+//
+//	[{"name": "timeouts", "codes": ["H12"], "threshold": 10, "window": "1m",
+//	  "cooldown": "15m", "webhooks": ["https://example.com/hook"]}]
+func ParseRules(data []byte) ([]Rule, error) {
+	var raw []ruleJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(raw))
+	for i, r := range raw {
+		rule := Rule{
+			Name:      r.Name,
+			Tokens:    r.Tokens,
+			Codes:     r.Codes,
+			Threshold: r.Threshold,
+			Webhooks:  r.Webhooks,
+		}
+
+		var err error
+		if r.Regexp != "" {
+			if rule.Regexp, err = regexp.Compile(r.Regexp); err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i, err)
+			}
+		}
+		if r.Window != "" {
+			if rule.Window, err = time.ParseDuration(r.Window); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid window: %v", i, err)
+			}
+		}
+		if r.Cooldown != "" {
+			if rule.Cooldown, err = time.ParseDuration(r.Cooldown); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid cooldown: %v", i, err)
+			}
+		}
+
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validate checks r and fills in defaults.
+func (r *Rule) validate() error {
+	switch {
+	case r.Name == "":
+		return ErrNoName
+	case len(r.Codes) == 0 && r.Regexp == nil:
+		return ErrNoMatch
+	case len(r.Webhooks) == 0:
+		return ErrNoWebhooks
+	case r.Threshold < 0 || r.Window < 0 || r.Cooldown < 0:
+		return fmt.Errorf("rule %q has a negative threshold, window or cooldown", r.Name)
+	}
+
+	for _, hook := range r.Webhooks {
+		u, err := url.Parse(hook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("rule %q has an invalid webhook %q", r.Name, hook)
+		}
+	}
+	for i, code := range r.Codes {
+		r.Codes[i] = strings.ToUpper(code)
+	}
+	if r.Threshold == 0 {
+		r.Threshold = 1
+	}
+	if r.Window == 0 {
+		r.Window = DefaultWindow
+	}
+	return nil
+}
+
+// appliesTo reports whether r covers token.
+func (r *Rule) appliesTo(token string) bool {
+	if len(r.Tokens) == 0 {
+		return true
+	}
+	for _, t := range r.Tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether m matches r, along with its error code if any.
+func (r *Rule) match(m *syslog.Message) (string, bool) {
+	code := ErrorCode(m)
+	if len(r.Codes) > 0 {
+		found := false
+		for _, c := range r.Codes {
+			if c == code {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return code, false
+		}
+	}
+	if r.Regexp != nil && !r.Regexp.MatchString(m.Message) {
+		return code, false
+	}
+	return code, true
+}
+
+// errorCodeRe matches the "Error R14 (Memory quota exceeded)" form of the
+// Heroku errors logged by the platform for dynos.
+var errorCodeRe = regexp.MustCompile(`\bError ([HRL][0-9]{2})\b`)
+
+// ErrorCode returns the Heroku error code, eg "H12", carried by m, or "".
+// Router lines carry it in their code field, other platform lines as
+// "Error R14 (...)".
+func ErrorCode(m *syslog.Message) string {
+	if m.AppName != "heroku" {
+		return ""
+	}
+	if syslog.IsRouter(m) {
+		if rl, err := syslog.ParseRouter(m.Message); err == nil {
+			return rl.Code
+		}
+	}
+	if match := errorCodeRe.FindStringSubmatch(m.Message); match != nil {
+		return match[1]
+	}
+	return ""
+}
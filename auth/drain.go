@@ -7,18 +7,31 @@ import (
 
 type drainTokenAuth struct {
 	handler http.Handler
-	tokens  map[string]bool
+	tokens  DrainTokens
 }
 
-// DrainTokenAuth is an authentication middleware matching a set of tokens against the Logplex-Drain-Token header.
-func DrainTokenAuth(tokens string) func(http.Handler) http.Handler {
-	set := make(map[string]bool)
+// DrainTokens is a set of drain tokens allowed to send logs.
+type DrainTokens map[string]bool
 
+// ParseDrainTokens parses comma separated drain tokens.
+func ParseDrainTokens(tokens string) DrainTokens {
+	set := make(DrainTokens)
 	for _, token := range strings.Split(tokens, ",") {
 		if token != "" {
 			set[token] = true
 		}
 	}
+	return set
+}
+
+// Allows reports whether token may send logs. An empty set allows every token.
+func (d DrainTokens) Allows(token string) bool {
+	return len(d) == 0 || d[token]
+}
+
+// DrainTokenAuth is an authentication middleware matching a set of tokens against the Logplex-Drain-Token header.
+func DrainTokenAuth(tokens string) func(http.Handler) http.Handler {
+	set := ParseDrainTokens(tokens)
 
 	fn := func(h http.Handler) http.Handler {
 		return &drainTokenAuth{
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"goji.io/pattern"
+)
+
+type readAuth struct {
+	handler http.Handler
+	keys    map[string]string
+	basic   map[string]string
+	bearers []string
+}
+
+// ReadAuth is an authentication middleware for endpoints reading a drain's
+// logs. A request is allowed if it presents the read key configured for the
+// drain token in the :token path parameter, or any of the shared basic auth
+// or bearer credentials. Keys are "token:key" pairs, basic credentials are
+// "user:password" pairs and bearers are plain tokens, each comma separated.
+// If nothing is configured every request is allowed.
+//
+// Read keys and bearer tokens are presented as "Authorization: Bearer <key>",
+// read keys may also be presented as the basic auth password.
+func ReadAuth(keys, basic, bearers string) func(http.Handler) http.Handler {
+	var (
+		keySet    = pairs(keys)
+		basicSet  = pairs(basic)
+		bearerSet []string
+	)
+
+	for _, token := range strings.Split(bearers, ",") {
+		if token != "" {
+			bearerSet = append(bearerSet, token)
+		}
+	}
+
+	fn := func(h http.Handler) http.Handler {
+		return &readAuth{
+			handler: h,
+			keys:    keySet,
+			basic:   basicSet,
+			bearers: bearerSet,
+		}
+	}
+	return fn
+}
+
+func (a readAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.authenticate(r) == false {
+		if len(a.basic) > 0 || len(a.keys) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="log-boom"`)
+		}
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+
+	a.handler.ServeHTTP(w, r)
+}
+
+func (a readAuth) authenticate(r *http.Request) bool {
+	if len(a.keys) == 0 && len(a.basic) == 0 && len(a.bearers) == 0 {
+		return true
+	}
+
+	var secret string
+	if user, pass, ok := r.BasicAuth(); ok {
+		if want, ok := a.basic[user]; ok && equal(pass, want) {
+			return true
+		}
+		secret = pass
+	} else if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		secret = strings.TrimPrefix(h, "Bearer ")
+		if a.bearer(secret) {
+			return true
+		}
+	}
+	if secret == "" {
+		return false
+	}
+
+	token, _ := r.Context().Value(pattern.Variable("token")).(string)
+	if want, ok := a.keys[token]; ok && equal(secret, want) {
+		return true
+	}
+
+	return false
+}
+
+// bearer reports whether secret is one of the bearer tokens, comparing it
+// against every one in constant time.
+func (a readAuth) bearer(secret string) bool {
+	found := false
+	for _, b := range a.bearers {
+		if equal(secret, b) {
+			found = true
+		}
+	}
+	return found
+}
+
+// pairs parses comma separated "name:value" pairs.
+func pairs(s string) map[string]string {
+	set := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			continue
+		}
+		set[pair[:i]] = pair[i+1:]
+	}
+	return set
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
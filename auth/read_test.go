@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goji.io/pattern"
+)
+
+func TestReadAuth(t *testing.T) {
+	var (
+		keys    = "t.1:key1,t.2:key2"
+		basic   = "user:password"
+		bearers = "bearer1,bearer2"
+	)
+
+	for _, tt := range []struct {
+		name                 string
+		keys, basic, bearers string
+		user, pass, bearer   string
+		status               int
+		challenge            bool
+	}{
+		{"unconfigured", "", "", "", "", "", "", 200, false},
+		{"unconfigured with credentials", "", "", "", "user", "anything", "", 200, false},
+
+		{"key as bearer", keys, basic, bearers, "", "", "key1", 200, false},
+		{"key as basic password", keys, basic, bearers, "anyone", "key1", "", 200, false},
+		{"key of another token", keys, basic, bearers, "", "", "key2", 401, true},
+		{"wrong key", keys, "", "", "", "", "key3", 401, true},
+
+		{"basic", keys, basic, bearers, "user", "password", "", 200, false},
+		{"basic wrong password", keys, basic, bearers, "user", "wrong", "", 401, true},
+		{"basic unknown user", keys, basic, bearers, "other", "password", "", 401, true},
+
+		{"bearer", keys, basic, bearers, "", "", "bearer2", 200, false},
+		{"bearer wrong", keys, basic, bearers, "", "", "bearer", 401, true},
+		{"bearer as basic password", "", "", bearers, "user", "bearer1", "", 401, false},
+		{"bearers only, no credentials", "", "", bearers, "", "", "", 401, false},
+
+		{"no credentials", keys, basic, bearers, "", "", "", 401, true},
+	} {
+		h := ReadAuth(tt.keys, tt.basic, tt.bearers)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		}))
+
+		r := httptest.NewRequest("GET", "/list/t.1", nil)
+		r = r.WithContext(context.WithValue(r.Context(), pattern.Variable("token"), "t.1"))
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		if tt.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+			t.Errorf("%s: WWW-Authenticate set = %v, want %v", tt.name, got, tt.challenge)
+		}
+	}
+}
+
+func TestPairs(t *testing.T) {
+	got := pairs("a:1,b:2:3,:4,c:,d,e:5")
+	want := map[string]string{"a": "1", "b": "2:3", "e": "5"}
+	if len(got) != len(want) {
+		t.Fatalf("pairs = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("pairs = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/heroku/log-boom/syslog"
+)
+
+const (
+	// DefaultURL is the log-boom server used when neither --url nor
+	// $LOG_BOOM_URL are set.
+	DefaultURL = "http://localhost:5000"
+
+	// MaxBackoff is the longest tail waits between reconnection attempts.
+	MaxBackoff = 30 * time.Second
+)
+
+const usage = `Usage: log-boom-cli <command> [options]
+
+Commands:
+  dump    print the newest lines buffered for a drain token
+  tail    print the newest lines buffered for a drain token, then follow
+
+Run 'log-boom-cli <command> -h' for the options of a command.
+`
+
+type client struct {
+	base    *url.URL
+	key     string
+	basic   string
+	json    bool
+	out     io.Writer
+	backoff time.Duration // the first wait between reconnection attempts
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "dump":
+		err = dump(os.Args[2:])
+	case "tail":
+		err = tail(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// flags registers the options shared by every command.
+func flags(name string) (*flag.FlagSet, *client, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c := &client{out: os.Stdout, backoff: time.Second}
+
+	token := fs.String("token", "", "drain token to read (required)")
+	fs.String("url", env("LOG_BOOM_URL", DefaultURL), "log-boom server url ($LOG_BOOM_URL)")
+	fs.StringVar(&c.key, "key", os.Getenv("LOG_BOOM_KEY"), "read key or bearer token ($LOG_BOOM_KEY)")
+	fs.StringVar(&c.basic, "basic", os.Getenv("LOG_BOOM_BASIC_AUTH"), "basic auth user:password ($LOG_BOOM_BASIC_AUTH)")
+	fs.BoolVar(&c.json, "json", false, "print each line as a JSON object")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: log-boom-cli %s [options]\n\n", name)
+		fs.PrintDefaults()
+	}
+
+	return fs, c, token
+}
+
+// parse parses args and validates the shared options.
+func parse(fs *flag.FlagSet, c *client, token *string, args []string) error {
+	fs.Parse(args)
+
+	if *token == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	base := fs.Lookup("url").Value.String()
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid url %q", base)
+	}
+	c.base = u
+	return nil
+}
+
+func dump(args []string) error {
+	fs, c, token := flags("dump")
+	n := fs.Int("n", 100, "number of lines to print, 0 for all")
+	if err := parse(fs, c, token, args); err != nil {
+		return err
+	}
+	return c.dump(*token, *n)
+}
+
+// dump prints the newest n lines buffered for token, or all of them if n is
+// 0, oldest first. Lines are read as JSON entries, one per line, so that
+// multi-line messages are kept whole.
+func (c *client) dump(token string, n int) error {
+	path := "/list/" + token
+	if n > 0 {
+		path += fmt.Sprintf("?order=newest&n=%d", n)
+	}
+
+	resp, err := c.get(path, "application/x-ndjson", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var (
+		entries []syslog.Entry
+		dec     = json.NewDecoder(resp.Body)
+	)
+	for {
+		var e syslog.Entry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	if n > 0 {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	for _, e := range entries {
+		c.printEntry(e)
+	}
+	return nil
+}
+
+func tail(args []string) error {
+	fs, c, token := flags("tail")
+	n := fs.Int("n", 10, "number of buffered lines to print before following, 0 for all")
+	if err := parse(fs, c, token, args); err != nil {
+		return err
+	}
+	return c.tail(*token, *n)
+}
+
+// tail prints the newest n lines buffered for token, or all of them if n is
+// 0, and then follows new lines. Dropped connections are retried, resuming
+// after the last line received, until the server returns an error retrying
+// won't fix.
+func (c *client) tail(token string, n int) error {
+	var (
+		path    = "/tail/" + token
+		last    string
+		backoff = c.backoff
+	)
+	for {
+		live, err := c.follow(path, n, &last)
+		if permanent(err) {
+			return err
+		}
+		if live {
+			backoff = c.backoff
+		}
+
+		fmt.Fprintf(os.Stderr, "disconnected: %v, reconnecting in %s\n", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+}
+
+// event is a Server-Sent Event.
+type event struct {
+	id   string
+	kind string
+	data string
+}
+
+// follow reads a single tail event stream until it ends, reporting whether
+// it got as far as following live lines. last is the id of the last line
+// received, sent as Last-Event-ID so the server resumes after it. The
+// buffered lines sent first are held until the "live" event; on a fresh
+// start only the newest n are printed, when resuming all of them are, as
+// the server only sends those after last.
+func (c *client) follow(path string, n int, last *string) (bool, error) {
+	resuming := *last != ""
+	resp, err := c.get(path, "text/event-stream", *last)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		r        = bufio.NewReader(resp.Body)
+		ev       event
+		data     []string
+		snapshot []event
+		live     bool
+	)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return live, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		switch {
+		case strings.HasPrefix(line, ":"):
+			continue
+		case strings.HasPrefix(line, "id:"):
+			ev.id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+			continue
+		case strings.HasPrefix(line, "event:"):
+			ev.kind = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		case strings.HasPrefix(line, "data:"):
+			d := strings.TrimPrefix(line, "data:")
+			data = append(data, strings.TrimPrefix(d, " "))
+			continue
+		case line != "":
+			continue
+		}
+
+		// A blank line dispatches the event, if it has any data.
+		if data == nil {
+			ev = event{}
+			continue
+		}
+		e := ev
+		e.data = strings.Join(data, "\n")
+		ev, data = event{}, nil
+
+		switch e.kind {
+		case "error":
+			return live, errors.New(e.data)
+		case "live":
+			live = true
+			if !resuming && n > 0 && len(snapshot) > n {
+				snapshot = snapshot[len(snapshot)-n:]
+			}
+			for _, s := range snapshot {
+				c.print(s.data)
+			}
+			snapshot = nil
+			if e.id != "" {
+				*last = e.id
+			}
+		case "", "message":
+			if !live {
+				snapshot = append(snapshot, e)
+				continue
+			}
+			c.print(e.data)
+			if e.id != "" {
+				*last = e.id
+			}
+		}
+	}
+}
+
+// get requests path, sending lastEventID as Last-Event-ID if it is set.
+func (c *client) get(path, accept, lastEventID string) (*http.Response, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + ref.Path
+	u.RawQuery = ref.RawQuery
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	if user := strings.SplitN(c.basic, ":", 2); len(user) == 2 {
+		req.SetBasicAuth(user[0], user[1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return resp, nil
+	case 401:
+		resp.Body.Close()
+		return nil, errUnauthorized
+	default:
+		resp.Body.Close()
+		return nil, &statusError{path: u.Path, status: resp.Status, code: resp.StatusCode}
+	}
+}
+
+var errUnauthorized = errors.New(http.StatusText(401))
+
+// statusError is returned for responses other than 200 and 401.
+type statusError struct {
+	path   string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s returned %s", e.path, e.status)
+}
+
+// permanent reports whether retrying a request which failed with err won't
+// help: client errors, and 501 Not Implemented, returned for tail when the
+// datastore can't follow lines.
+func permanent(err error) bool {
+	if err == errUnauthorized {
+		return true
+	}
+	e, ok := err.(*statusError)
+	return ok && (e.code/100 == 4 || e.code == 501)
+}
+
+// print writes line raw, or parsed into JSON with --json.
+func (c *client) print(line string) {
+	line = strings.TrimSuffix(line, "\n")
+	if !c.json {
+		fmt.Fprintln(c.out, line)
+		return
+	}
+
+	b, _ := json.Marshal(syslog.NewEntry(line))
+	fmt.Fprintf(c.out, "%s\n", b)
+}
+
+// printEntry writes e as an RFC5424 line rebuilt from its fields, or as JSON
+// with --json. Entries of lines which weren't syslog are just their message.
+func (c *client) printEntry(e syslog.Entry) {
+	if c.json {
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(c.out, "%s\n", b)
+		return
+	}
+
+	line, err := e.Line()
+	if err != nil || e.Severity == nil {
+		line = e.Message
+	}
+	fmt.Fprintln(c.out, strings.TrimSuffix(line, "\n"))
+}
+
+func env(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, srv *httptest.Server) (*client, *bytes.Buffer) {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	return &client{base: u, key: "key", out: out, backoff: time.Millisecond}, out
+}
+
+func TestDump(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list/token" || r.URL.RawQuery != "order=newest&n=3" {
+			t.Errorf("requested %s", r.URL)
+		}
+		if got := r.Header.Get("Accept"); got != "application/x-ndjson" {
+			t.Errorf("Accept = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		// Newest first, as requested.
+		fmt.Fprintln(w, `{"message":"not syslog"}`)
+		fmt.Fprintln(w, `{"timestamp":"2026-10-17T00:00:00Z","host":"host","app":"app","proc":"web.1","severity":6,"message":"first\nsecond"}`)
+		fmt.Fprintln(w, `{"app":"app","severity":3,"message":"oldest"}`)
+	}))
+	defer srv.Close()
+
+	c, out := newTestClient(t, srv)
+	if err := c.dump("token", 3); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	want := "<11>1 - - app - - - oldest\n" +
+		"<14>1 2026-10-17T00:00:00Z host app web.1 - - first\nsecond\n" +
+		"not syslog\n"
+	if out.String() != want {
+		t.Errorf("dump printed\n%s\nwant\n%s", out, want)
+	}
+
+	c, out = newTestClient(t, srv)
+	c.json = true
+	if err := c.dump("token", 3); err != nil {
+		t.Fatalf("dump --json: %v", err)
+	}
+	want = `{"app":"app","severity":3,"message":"oldest"}` + "\n"
+	if line, _ := out.ReadString('\n'); line != want {
+		t.Errorf("dump --json printed %q first, want %q", line, want)
+	}
+}
+
+func TestTailReconnect(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns++
+		n := conns
+		mu.Unlock()
+
+		if got := r.Header.Get("Accept"); got != "text/event-stream" {
+			t.Errorf("Accept = %q", got)
+		}
+		want := map[int]string{1: "", 2: "4", 3: "5"}[n]
+		if got := r.Header.Get("Last-Event-ID"); got != want {
+			t.Errorf("connection %d: Last-Event-ID = %q, want %q", n, got, want)
+		}
+
+		switch n {
+		case 1:
+			fmt.Fprint(w, "id: 1\ndata: a\n\nid: 2\ndata: b\n\nid: 3\ndata: c\n\n")
+			fmt.Fprint(w, "id: 3\nevent: live\ndata: 3\n\n")
+			fmt.Fprint(w, ": heartbeat\n\nid: 4\ndata: d\n\n")
+		case 2:
+			// Resuming after 4: only the lines since are sent, and all of
+			// them are printed.
+			fmt.Fprint(w, "id: 5\ndata: e\ndata: f\n\nid: 5\nevent: live\ndata: 5\n\n")
+		default:
+			http.Error(w, http.StatusText(404), 404)
+		}
+	}))
+	defer srv.Close()
+
+	c, out := newTestClient(t, srv)
+	err := c.tail("token", 2)
+	if e, ok := err.(*statusError); !ok || e.code != 404 {
+		t.Fatalf("tail returned %v, want the 404", err)
+	}
+	if want := "b\nc\nd\ne\nf\n"; out.String() != want {
+		t.Errorf("tail printed %q, want %q", out, want)
+	}
+	if conns != 3 {
+		t.Errorf("tail connected %d times, want 3", conns)
+	}
+}
+
+func TestTailErrors(t *testing.T) {
+	for _, tt := range []struct {
+		codes []int // returned for each request in turn, the last repeated
+		err   func(error) bool
+		tries int
+	}{
+		{[]int{401}, func(err error) bool { return err == errUnauthorized }, 1},
+		{[]int{403}, permanent, 1},
+		{[]int{404}, permanent, 1},
+		{[]int{406}, permanent, 1},
+		{[]int{501}, permanent, 1},
+		{[]int{503, 502, 401}, func(err error) bool { return err == errUnauthorized }, 3},
+	} {
+		var (
+			mu    sync.Mutex
+			tries int
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			code := tt.codes[len(tt.codes)-1]
+			if tries < len(tt.codes) {
+				code = tt.codes[tries]
+			}
+			tries++
+			mu.Unlock()
+			http.Error(w, http.StatusText(code), code)
+		}))
+
+		c, _ := newTestClient(t, srv)
+		err := c.tail("token", 10)
+		srv.Close()
+
+		if !tt.err(err) {
+			t.Errorf("%v: tail returned %v", tt.codes, err)
+		}
+		if tries != tt.tries {
+			t.Errorf("%v: tail tried %d times, want %d", tt.codes, tries, tt.tries)
+		}
+	}
+}
+
+func TestDumpUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(401), 401)
+	}))
+	defer srv.Close()
+
+	c, out := newTestClient(t, srv)
+	if err := c.dump("token", 10); err != errUnauthorized {
+		t.Errorf("dump returned %v, want %v", err, errUnauthorized)
+	}
+	if out.Len() != 0 {
+		t.Errorf("dump printed %q", out)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/heroku/log-boom/syslog"
+)
+
+// format is an output format of /list.
+type format struct {
+	name        string
+	contentType string
+	write       func(w io.Writer, lines []string)
+}
+
+// formats are the output formats of /list, the first being the default.
+var formats = []format{
+	{"text", "text/plain; charset=utf-8", writeText},
+	{"json", "application/json", writeJSON},
+	{"ndjson", "application/x-ndjson", writeNDJSON},
+}
+
+// Format negotiation errors
+var (
+	errUnknownFormat = errors.New("unknown format")
+	errNotAcceptable = errors.New("no acceptable format")
+)
+
+// negotiate picks the format of a list response from the format query
+// parameter, or failing that the Accept header.
+func negotiate(r *http.Request) (format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if f.name == name {
+				return f, nil
+			}
+		}
+		return format{}, errUnknownFormat
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formats[0], nil
+	}
+
+	var (
+		best  format
+		bestQ float64
+	)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		for _, f := range formats {
+			if matchMediaType(mediaType, f.contentType) {
+				best, bestQ = f, q
+				break
+			}
+		}
+	}
+	if bestQ == 0 {
+		return format{}, errNotAcceptable
+	}
+	return best, nil
+}
+
+// matchMediaType reports whether the media range pattern, eg "text/*",
+// covers contentType.
+func matchMediaType(pattern, contentType string) bool {
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	if pattern == "*/*" || pattern == contentType {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") &&
+		strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+}
+
+// writeText writes lines newline terminated.
+func writeText(w io.Writer, lines []string) {
+	for _, line := range lines {
+		writePlain(w, line)
+	}
+}
+
+// writeJSON writes lines as a JSON array of entries.
+func writeJSON(w io.Writer, lines []string) {
+	io.WriteString(w, "[")
+	for i, line := range lines {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		b, _ := json.Marshal(syslog.NewEntry(line))
+		w.Write(b)
+	}
+	io.WriteString(w, "]\n")
+}
+
+// writeNDJSON writes lines as entries, one JSON object per line.
+func writeNDJSON(w io.Writer, lines []string) {
+	for _, line := range lines {
+		b, _ := json.Marshal(syslog.NewEntry(line))
+		w.Write(b)
+		io.WriteString(w, "\n")
+	}
+}
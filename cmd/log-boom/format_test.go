@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+)
+
+func TestNegotiate(t *testing.T) {
+	for _, tt := range []struct {
+		accept, format string
+		want           string
+		err            error
+	}{
+		{"", "", "text", nil},
+		{"application/json", "", "json", nil},
+		{"application/x-ndjson; charset=utf-8", "", "ndjson", nil},
+
+		// The highest q-value wins, and the first of equal ones.
+		{"text/plain;q=0.5, application/json", "", "json", nil},
+		{"application/json;q=0.2, application/x-ndjson;q=0.9, text/plain;q=0.5", "", "ndjson", nil},
+		{"application/x-ndjson, application/json", "", "ndjson", nil},
+		{"application/json;q=0.5, text/plain;q=0.5", "", "json", nil},
+		{"image/png, application/json;q=0.1", "", "json", nil},
+		{"application/json;q=bad, text/plain;q=0.1", "", "text", nil},
+
+		// Wildcards take the first format they cover.
+		{"*/*", "", "text", nil},
+		{"application/*", "", "json", nil},
+		{"text/*;q=0.1, application/*", "", "json", nil},
+		{"image/png, */*;q=0.1", "", "text", nil},
+
+		{"image/png", "", "", errNotAcceptable},
+		{"application/json;q=0", "", "", errNotAcceptable},
+		{"image/*, text/html", "", "", errNotAcceptable},
+
+		// The format parameter takes precedence over the header.
+		{"application/json", "text", "text", nil},
+		{"text/plain", "ndjson", "ndjson", nil},
+		{"image/png", "json", "json", nil},
+		{"application/json", "xml", "", errUnknownFormat},
+	} {
+		r := httptest.NewRequest("GET", "/list/t?format="+tt.format, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+
+		f, err := negotiate(r)
+		if err != tt.err || f.name != tt.want {
+			t.Errorf("Accept %q, format %q: negotiated %q, %v, want %q, %v", tt.accept, tt.format, f.name, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestListHandlerFormat(t *testing.T) {
+	e := newTestEnv(t)
+	mustInsert(t, e.db, "t", "<11>1 2026-10-17T00:00:00Z host app web.1 - - failed", "not syslog")
+
+	for _, tt := range []struct {
+		accept, query string
+		status        int
+		contentType   string
+		body          string
+	}{
+		{"", "", 200, "text/plain; charset=utf-8", "<11>1 2026-10-17T00:00:00Z host app web.1 - - failed\nnot syslog\n"},
+		{
+			"application/*", "", 200, "application/json",
+			`[{"timestamp":"2026-10-17T00:00:00Z","host":"host","app":"app","proc":"web.1","severity":3,"message":"failed"},{"message":"not syslog"}]` + "\n",
+		},
+		{
+			"application/json", "?format=ndjson", 200, "application/x-ndjson",
+			`{"timestamp":"2026-10-17T00:00:00Z","host":"host","app":"app","proc":"web.1","severity":3,"message":"failed"}` + "\n" +
+				`{"message":"not syslog"}` + "\n",
+		},
+		{"image/png", "", 406, "text/plain; charset=utf-8", "Not Acceptable\n"},
+		{"", "?format=xml", 400, "text/plain; charset=utf-8", "Bad Request\n"},
+	} {
+		r := httptest.NewRequest("GET", "/list/t"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		newTestMux(e).ServeHTTP(w, r)
+
+		if w.Code != tt.status || w.Header().Get("Content-Type") != tt.contentType || w.Body.String() != tt.body {
+			t.Errorf("Accept %q, query %q: got %d %q %q, want %d %q %q",
+				tt.accept, tt.query, w.Code, w.Header().Get("Content-Type"), w.Body.String(),
+				tt.status, tt.contentType, tt.body)
+		}
+	}
+}
+
+// newTestMux routes list requests to e.
+func newTestMux(e *env) http.Handler {
+	mux := goji.NewMux()
+	mux.HandleFunc(pat.Get("/list/:token"), e.listHandler)
+	return mux
+}
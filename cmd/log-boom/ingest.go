@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/heroku/log-boom/syslog"
+)
+
+// errLineTooLarge is returned for entries whose line exceeds MAX_FRAME_SIZE.
+var errLineTooLarge = errors.New("entry larger than the maximum frame size")
+
+// ingestHandler stores the JSON entries of a request body, given either as
+// an array or as newline delimited JSON, in the schema /list writes them in,
+// for senders other than Logplex.
+// Entries are stored as RFC5424 lines, like drained ones, timestamped with
+// the time of the request if they have no timestamp.
+func (e *env) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && mediaType != "application/x-ndjson") {
+		http.Error(w, http.StatusText(415), 415)
+		return
+	}
+	token := r.Header.Get("Logplex-Drain-Token")
+
+	// As with drain requests, entries are stored in batches as they are
+	// read, so earlier batches are kept if a later entry is invalid.
+	var (
+		dec   = newEntryDecoder(r.Body, entryLimit(e.maxFrame))
+		batch = make([]string, 0, e.batchSize)
+		now   = time.Now().UTC()
+	)
+	for {
+		entry, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		var line string
+		if err == nil {
+			if entry.Timestamp == nil {
+				entry.Timestamp = &now
+			}
+			if line, err = entry.Line(); err == nil && len(line) > e.maxFrame {
+				err = errLineTooLarge
+			}
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":  "ingest",
+				"err": err,
+			}).Error("could not process body")
+			setRejected(w, len(batch)+1)
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+
+		batch = append(batch, line)
+		if len(batch) < e.batchSize {
+			continue
+		}
+		if err := e.insert(token, batch); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "ingest",
+				"err": err,
+			}).Error("could not store logs")
+			setRejected(w, len(batch))
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		batch = batch[:0]
+	}
+	if len(batch) > 0 {
+		if err := e.insert(token, batch); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "ingest",
+				"err": err,
+			}).Error("could not store logs")
+			setRejected(w, len(batch))
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(204)
+}
+
+// countEntries counts the entries in the body of an ingest request rejected
+// before it was read, up to the first invalid one.
+func (e *env) countEntries(r *http.Request) int {
+	dec := newEntryDecoder(r.Body, entryLimit(e.maxFrame))
+	n := 0
+	for {
+		if _, err := dec.next(); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+// entryLimit is the most bytes of a body read for a single entry whose line
+// is at most maxFrame bytes, allowing for every byte of it being escaped.
+func entryLimit(maxFrame int) int64 {
+	return 6*int64(maxFrame) + 1024
+}
+
+// entryDecoder reads entries one at a time from either a JSON array or a
+// stream of JSON objects, such as newline delimited JSON.
+type entryDecoder struct {
+	lr      *limitReader
+	r       *bufio.Reader
+	dec     *json.Decoder
+	array   bool
+	started bool
+}
+
+// newEntryDecoder returns an entryDecoder reading at most limit bytes of r
+// for each entry, so a single huge entry fails with errLineTooLarge rather
+// than being read into memory.
+func newEntryDecoder(r io.Reader, limit int64) *entryDecoder {
+	lr := &limitReader{r: r, limit: limit}
+	br := bufio.NewReader(lr)
+	return &entryDecoder{lr: lr, r: br, dec: json.NewDecoder(br)}
+}
+
+// next returns the next entry, or io.EOF once there are no more.
+func (d *entryDecoder) next() (syslog.Entry, error) {
+	var entry syslog.Entry
+	d.lr.n = d.lr.limit
+
+	if !d.started {
+		d.started = true
+		c, err := d.peek()
+		if err != nil {
+			return entry, err
+		}
+		if c == '[' {
+			if _, err := d.dec.Token(); err != nil {
+				return entry, err
+			}
+			d.array = true
+		}
+	}
+
+	if d.array && !d.dec.More() {
+		if _, err := d.dec.Token(); err != nil {
+			return entry, err
+		}
+		return entry, io.EOF
+	}
+	err := d.dec.Decode(&entry)
+	return entry, err
+}
+
+// peek returns the first byte of the body which isn't whitespace.
+func (d *entryDecoder) peek() (byte, error) {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, d.r.UnreadByte()
+	}
+}
+
+// limitReader reads from r until n bytes have been read, failing with
+// errLineTooLarge after that.
+type limitReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errLineTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	ds "github.com/heroku/log-boom/datastore"
+)
+
+func newTestEnv(t *testing.T) *env {
+	db, err := ds.NewInMemory(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &env{db: db, maxFrame: 100, batchSize: 2}
+}
+
+func TestIngestHandler(t *testing.T) {
+	const stamp = `"timestamp": "2026-10-17T00:00:00Z"`
+	huge := strings.Repeat(`\u0000`, 10000)
+
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		lines       []string
+	}{
+		{
+			"array", "application/json",
+			`[{` + stamp + `, "app": "worker", "proc": "2", "severity": 3, "message": "job failed"},
+			  {` + stamp + `, "message": "done\n"}]`,
+			204,
+			[]string{
+				"<11>1 2026-10-17T00:00:00Z - worker 2 - - job failed",
+				"<14>1 2026-10-17T00:00:00Z - - - - - done",
+			},
+		},
+		{
+			"ndjson", "application/x-ndjson; charset=utf-8",
+			`{` + stamp + `, "host": "h", "message": "one"}` + "\n" +
+				`{` + stamp + `, "message": "two"}` + "\n" +
+				`{` + stamp + `, "message": "three"}` + "\n",
+			204,
+			[]string{
+				"<14>1 2026-10-17T00:00:00Z h - - - - one",
+				"<14>1 2026-10-17T00:00:00Z - - - - - two",
+				"<14>1 2026-10-17T00:00:00Z - - - - - three",
+			},
+		},
+		{"empty", "application/x-ndjson", "", 204, nil},
+		{"empty array", "application/json", " [ ] ", 204, nil},
+		{
+			// The first batch is stored before the invalid entry is read.
+			"malformed", "application/x-ndjson",
+			`{` + stamp + `, "message": "one"}` + "\n" +
+				`{` + stamp + `, "message": "two"}` + "\n" +
+				`{"message": `,
+			400,
+			[]string{
+				"<14>1 2026-10-17T00:00:00Z - - - - - one",
+				"<14>1 2026-10-17T00:00:00Z - - - - - two",
+			},
+		},
+		{"invalid field", "application/json", `[{"app": "has space", "message": "x"}]`, 400, nil},
+		{"oversized line", "application/json", `[{"message": "` + strings.Repeat("x", 100) + `"}]`, 400, nil},
+		{"oversized entry", "application/json", `[{"message": "` + huge + `"}]`, 400, nil},
+		{"oversized value", "application/json", `[{"message": "x", "other": "` + strings.Repeat("x", 10000) + `"}]`, 400, nil},
+		{"unsupported", "text/plain", `{"message": "x"}`, 415, nil},
+		{"no content type", "", `{"message": "x"}`, 415, nil},
+	} {
+		e := newTestEnv(t)
+		r := httptest.NewRequest("POST", "/ingest", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		r.Header.Set("Logplex-Drain-Token", "t.1")
+		w := httptest.NewRecorder()
+		e.ingestHandler(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		got, err := e.db.List("t.1")
+		if err == ds.ErrNoSuchToken {
+			err = nil
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.lines) {
+			t.Errorf("%s: stored %q, %v, want %q", tt.name, got, err, tt.lines)
+		}
+	}
+}
+
+// TestIngestHandlerLimit checks a huge entry is rejected without reading
+// the whole of it.
+func TestIngestHandlerLimit(t *testing.T) {
+	e := newTestEnv(t)
+	body := &countingReader{r: strings.NewReader(`{"message": "` + strings.Repeat("x", 1<<20) + `"}`)}
+	r := httptest.NewRequest("POST", "/ingest", body)
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	e.ingestHandler(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if max := entryLimit(e.maxFrame) + 4096; body.n > max {
+		t.Errorf("read %d bytes of the body, want at most %d", body.n, max)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
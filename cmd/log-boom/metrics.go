@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	ds "github.com/heroku/log-boom/datastore"
+	"github.com/heroku/log-boom/metrics"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	framesReceived = registry.NewCounter(
+		"log_boom_frames_received_total",
+		"Syslog frames received.",
+	)
+	framesRejected = registry.NewCounter(
+		"log_boom_frames_rejected_total",
+		"Syslog frames in rejected drain requests, and JSON entries rejected by /ingest, per response status.",
+		"status",
+	)
+	frameCountMismatches = registry.NewCounter(
+		"log_boom_frame_count_mismatches_total",
+		"Drain requests whose Logplex-Msg-Count did not match the frames in the body.",
+	)
+	messagesDropped = registry.NewCounter(
+		"log_boom_syslog_messages_dropped_total",
+		"Syslog messages received by a syslog listener but not stored, per listener and reason.",
+		"listener", "reason",
+	)
+	insertDuration = registry.NewHistogram(
+		"log_boom_insert_duration_seconds",
+		"Latency of datastore inserts.",
+		metrics.DefaultBuckets,
+	)
+	listDuration = registry.NewHistogram(
+		"log_boom_list_duration_seconds",
+		"Latency of datastore lists.",
+		metrics.DefaultBuckets,
+	)
+)
+
+// pooler is implemented by datastores backed by a connection pool.
+type pooler interface {
+	PoolAvail() int
+	PoolSize() int
+}
+
+// registerDatastoreMetrics registers gauges for whatever db can report.
+func registerDatastoreMetrics(db ds.Datastore) {
+	if s, ok := db.(ds.Sizer); ok {
+		registry.NewGaugeFunc(
+			"log_boom_buffer_lines",
+			"Lines currently buffered, per drain token.",
+			func() []metrics.Sample {
+				sizes, err := s.Sizes()
+				if err != nil {
+					return nil
+				}
+				samples := make([]metrics.Sample, 0, len(sizes))
+				for token, n := range sizes {
+					samples = append(samples, metrics.Sample{
+						LabelValues: []string{token},
+						Value:       float64(n),
+					})
+				}
+				return samples
+			},
+			"token",
+		)
+	}
+
+	if p, ok := db.(pooler); ok {
+		registry.NewGaugeFunc(
+			"log_boom_redis_pool_available",
+			"Idle connections in the redis pool.",
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(p.PoolAvail())}}
+			},
+		)
+		registry.NewGaugeFunc(
+			"log_boom_redis_pool_size",
+			"Configured size of the redis pool.",
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(p.PoolSize())}}
+			},
+		)
+	}
+}
+
+// countRejected returns a middleware counting the frames or entries of
+// requests which are not accepted, whether by authentication or the handler.
+// Handlers which read some of the body before failing report how many they
+// rejected with setRejected, otherwise count is used.
+func countRejected(count func(r *http.Request) int) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w, status: 200}
+			h.ServeHTTP(rec, r)
+
+			if rec.status < 300 {
+				return
+			}
+			n := rec.rejected
+			if n == 0 {
+				n = count(r)
+			}
+			framesRejected.Add(float64(n), strconv.Itoa(rec.status))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// msgCount returns the frames of a drain request, as given by its
+// Logplex-Msg-Count header, or 1 if it has none.
+func msgCount(r *http.Request) int {
+	frames, err := strconv.Atoi(r.Header.Get("Logplex-Msg-Count"))
+	if err != nil || frames < 1 {
+		return 1
+	}
+	return frames
+}
+
+// setRejected reports that n frames or entries of the request answered by w
+// were rejected, for countRejected.
+func setRejected(w http.ResponseWriter, n int) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.rejected = n
+	}
+}
+
+// statusRecorder captures the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	rejected int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
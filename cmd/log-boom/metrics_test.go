@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rejected returns the value of log_boom_frames_rejected_total for status.
+func rejected(t *testing.T, status int) float64 {
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	prefix := `log_boom_frames_rejected_total{status="` + strconv.Itoa(status) + `"} `
+	s := bufio.NewScanner(w.Body)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), prefix) {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(s.Text(), prefix), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestCountRejected(t *testing.T) {
+	e := newTestEnv(t)
+	unauthorized := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(401), 401)
+	})
+	invalid := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(400), 400)
+	})
+	const entry = `{"message": "x"}` + "\n"
+
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		header  string
+		value   string
+		body    string
+		status  int
+		want    float64
+	}{
+		{"accepted", countRejected(e.countEntries)(http.HandlerFunc(e.ingestHandler)), "Content-Type", "application/x-ndjson", entry, 204, 0},
+		{"unauthorized entries", countRejected(e.countEntries)(unauthorized), "Content-Type", "application/x-ndjson", strings.Repeat(entry, 3), 401, 3},
+		{"unauthorized array", countRejected(e.countEntries)(unauthorized), "Content-Type", "application/json", `[` + entry + `,` + entry + `]`, 401, 2},
+		// One stored batch of 2, then one entry pending and the invalid one.
+		{"invalid entry", countRejected(e.countEntries)(http.HandlerFunc(e.ingestHandler)), "Content-Type", "application/x-ndjson", strings.Repeat(entry, 3) + `{"message": 1}`, 400, 2},
+		{"unsupported", countRejected(e.countEntries)(http.HandlerFunc(e.ingestHandler)), "Content-Type", "text/plain", "not json", 415, 0},
+		{"drain", countRejected(msgCount)(invalid), "Logplex-Msg-Count", "5", "", 400, 5},
+		{"drain without count", countRejected(msgCount)(invalid), "Logplex-Msg-Count", "", "", 400, 1},
+	} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		r.Header.Set("Logplex-Drain-Token", "t.1")
+		r.Header.Set(tt.header, tt.value)
+
+		before := rejected(t, tt.status)
+		w := httptest.NewRecorder()
+		tt.handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if got := rejected(t, tt.status) - before; got != tt.want {
+			t.Errorf("%s: counted %v rejected, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,29 +1,54 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/heroku/log-boom/alert"
 	"github.com/heroku/log-boom/auth"
+	"github.com/heroku/log-boom/config"
 	ds "github.com/heroku/log-boom/datastore"
+	"github.com/heroku/log-boom/ingest"
 	"github.com/heroku/log-boom/syslog"
 	"goji.io"
 	"goji.io/pat"
 )
 
 const (
-	// DefaultRedisPoolSize is the default pool size (defaults to 4).
-	DefaultRedisPoolSize = 4
-
-	// DefaultBufferSize is the default size of the ring bugger in log lines.
-	DefaultBufferSize = 1500
+	// TailHeartbeat is how often an idle event stream is sent a comment to
+	// keep intermediate proxies from closing it.
+	TailHeartbeat = 30 * time.Second
 )
 
 type env struct {
-	db ds.Datastore
+	cfg         *config.Config
+	db          ds.Datastore
+	alerts      *alert.Alerter
+	strictCount bool
+	maxFrame    int
+	batchSize   int
+	heartbeat   time.Duration // how often idle event streams are sent a comment
+
+	// listeners are the syslog listeners running alongside the HTTP server.
+	listeners []io.Closer
+
+	// done is closed on shutdown to end live tails, which would otherwise
+	// hold the server open.
+	done chan struct{}
 }
 
 func (e *env) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -57,16 +82,39 @@ func (e *env) logsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	token := r.Header.Get("Logplex-Drain-Token")
 	count, err := strconv.ParseInt(r.Header.Get("Logplex-Msg-Count"), 10, 32)
-	if err != nil {
+	if err != nil || count < 0 {
 		log.WithFields(log.Fields{
 			"at":  "logs",
 			"err": err,
 		}).Error("unable to parse Logplex-Msg-Count header")
 		http.Error(w, http.StatusText(400), 400)
+		return
 	}
 
-	lines, err := syslog.Scan(r.Body, count)
-	if err != nil {
+	// Frames are stored in batches as they are read, so in strict mode a
+	// body with fewer frames than its header claims is only rejected after
+	// any earlier, full batches have been stored.
+	var (
+		scanner = syslog.NewFramedScanner(r.Body, e.maxFrame, e.cfg.LogsFraming)
+		batch   = make([]string, 0, e.batchSize)
+		frames  int64
+	)
+	for {
+		batch = scanner.ReadBatch(batch[:0], e.batchSize)
+		frames += int64(len(batch))
+		if len(batch) < e.batchSize || (e.strictCount && frames > count) {
+			break
+		}
+		if err := e.insert(token, batch); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "logs",
+				"err": err,
+			}).Error("could not store logs")
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
 		log.WithFields(log.Fields{
 			"at":  "logs",
 			"err": err,
@@ -74,24 +122,80 @@ func (e *env) logsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(400), 400)
 		return
 	}
-	_, err = e.db.Insert(token, lines)
-	if err != nil {
+	if frames != count {
 		log.WithFields(log.Fields{
-			"at":  "logs",
-			"err": err,
-		}).Error("could not store logs")
-		http.Error(w, http.StatusText(500), 500)
-		return
+			"at":       "logs",
+			"expected": count,
+			"actual":   frames,
+		}).Warn("Logplex-Msg-Count does not match frames in body")
+		frameCountMismatches.Inc()
+		if e.strictCount {
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+	}
+	if len(batch) > 0 {
+		if err := e.insert(token, batch); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "logs",
+				"err": err,
+			}).Error("could not store logs")
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
 	}
 
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(204)
 }
 
+// insert stores a batch of lines from a drain request.
+func (e *env) insert(token string, lines []string) error {
+	framesReceived.Add(float64(len(lines)))
+
+	start := time.Now()
+	_, err := e.db.Insert(token, lines)
+	insertDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+
+	if e.alerts != nil {
+		e.alerts.Observe(token, lines)
+	}
+	return nil
+}
+
 func (e *env) listHandler(w http.ResponseWriter, r *http.Request) {
 	token := pat.Param(r, "token")
 
-	logs, err := e.db.List(token)
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "list",
+			"err": err,
+		}).Error("unable to parse query")
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	f, err := negotiate(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "list",
+			"err": err,
+		}).Error("unable to negotiate format")
+		if err == errNotAcceptable {
+			http.Error(w, http.StatusText(406), 406)
+		} else {
+			http.Error(w, http.StatusText(400), 400)
+		}
+		return
+	}
+
+	start := time.Now()
+	page, err := e.db.Query(token, q)
+	listDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		log.WithFields(log.Fields{
 			"at":  "logs",
@@ -105,65 +209,512 @@ func (e *env) listHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if page.Next != 0 {
+		next := r.URL.Query()
+		next.Set("cursor", strconv.FormatInt(page.Next, 10))
+		w.Header().Set("Next-Cursor", strconv.FormatInt(page.Next, 10))
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Vary", "Accept")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(200)
-	for _, line := range logs {
-		w.Write([]byte(line))
+	f.write(w, page.Lines)
+}
+
+// parseQuery reads the n, cursor and order query parameters of a list request.
+func parseQuery(v url.Values) (ds.Query, error) {
+	var (
+		q   ds.Query
+		err error
+	)
+
+	if n := v.Get("n"); n != "" {
+		if q.Limit, err = strconv.Atoi(n); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid n %q", n)
+		}
+	}
+
+	if cursor := v.Get("cursor"); cursor != "" {
+		if q.Cursor, err = strconv.ParseInt(cursor, 10, 64); err != nil || q.Cursor < 0 {
+			return q, fmt.Errorf("invalid cursor %q", cursor)
+		}
 	}
+
+	switch order := v.Get("order"); order {
+	case "", "oldest":
+		q.Order = ds.OldestFirst
+	case "newest":
+		q.Order = ds.NewestFirst
+	default:
+		return q, fmt.Errorf("invalid order %q", order)
+	}
+
+	q.Filter, err = parseFilter(v)
+	return q, err
 }
 
-func main() {
-	listen := os.Getenv("LISTEN")
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("$PORT must be set")
+// parseFilter reads the app, proc, severity, since, until, q and re query
+// parameters of a list request.
+func parseFilter(v url.Values) (ds.Filter, error) {
+	f := ds.Filter{
+		AppName:  v.Get("app"),
+		ProcID:   v.Get("proc"),
+		Contains: v.Get("q"),
+	}
+
+	for _, p := range []string{f.AppName, f.ProcID} {
+		if _, err := path.Match(p, ""); err != nil {
+			return f, fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+
+	if severity := v.Get("severity"); severity != "" {
+		bounds := strings.SplitN(severity, "-", 2)
+		lo, err := syslog.ParseSeverity(bounds[0])
+		if err != nil {
+			return f, fmt.Errorf("invalid severity %q", severity)
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = syslog.ParseSeverity(bounds[1]); err != nil {
+				return f, fmt.Errorf("invalid severity %q", severity)
+			}
+		}
+		f.Severities = ds.SeverityRange(lo, hi)
+	}
+
+	var err error
+	if f.Since, err = parseTime(v.Get("since")); err != nil {
+		return f, err
+	}
+	if f.Until, err = parseTime(v.Get("until")); err != nil {
+		return f, err
+	}
+
+	if re := v.Get("re"); re != "" {
+		if f.Regexp, err = regexp.Compile(re); err != nil {
+			return f, fmt.Errorf("invalid re %q: %v", re, err)
+		}
+	}
+	return f, nil
+}
+
+// parseTime reads an RFC3339 time, or a duration before now such as "15m".
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+func (e *env) tailHandler(w http.ResponseWriter, r *http.Request) {
+	token := pat.Param(r, "token")
+
+	sub, ok := e.db.(ds.Subscriber)
+	if !ok {
+		http.Error(w, http.StatusText(501), 501)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, http.StatusText(500), 500)
+		return
 	}
-	keep, err := strconv.Atoi(os.Getenv("BUFFER_SIZE"))
+
+	// Subscribe before taking the snapshot so no lines are missed between the two.
+	s, err := sub.Subscribe(token)
 	if err != nil {
-		keep = DefaultBufferSize
+		log.WithFields(log.Fields{
+			"at":  "tail",
+			"err": err,
+		}).Error("could not subscribe")
+		http.Error(w, http.StatusText(500), 500)
+		return
 	}
+	defer s.Close()
 
-	e := &env{}
-	switch os.Getenv("DATASTORE") {
-	case "redis":
-		url, err := url.Parse(os.Getenv("REDIS_URL"))
-		if err != nil || url.Scheme != "redis" {
-			log.Fatal("$REDIS_URL must be set and valid")
+	// Event stream clients resuming after a disconnect send the id of the
+	// last line they received, and are only sent the lines after it.
+	var q ds.Query
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && id >= 0 {
+		q.Cursor = id + 1
+	}
+	page, err := e.db.Query(token, q)
+	if err == ds.ErrNoSuchToken {
+		page, err = &ds.Page{}, nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "tail",
+			"err": err,
+		}).Error("could find stored logs")
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	write := func(seq int64, line string) {
+		if sse {
+			writeEvent(w, seq, line)
+		} else {
+			writePlain(w, line)
+		}
+	}
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+
+	first := page.Last - int64(len(page.Lines)) + 1
+	for i, line := range page.Lines {
+		write(first+int64(i), line)
+	}
+	if sse {
+		// The live event carries the newest id too, so a client resumes
+		// from here even if no lines were sent.
+		if page.Last > 0 {
+			fmt.Fprintf(w, "id: %d\n", page.Last)
+		}
+		fmt.Fprintf(w, "event: live\ndata: %d\n\n", len(page.Lines))
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(e.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case line, ok := <-s.C:
+			if !ok {
+				log.WithFields(log.Fields{
+					"at":  "tail",
+					"err": s.Err(),
+				}).Info("subscription closed")
+				if sse && s.Err() != nil {
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", s.Err())
+				}
+				return
+			}
+			// Lines inserted between subscribing and the snapshot are
+			// published too.
+			if line.Seq > 0 && line.Seq <= page.Last {
+				continue
+			}
+			write(line.Seq, line.Text)
+			flusher.Flush()
+		case <-heartbeat.C:
+			if sse {
+				io.WriteString(w, ":\n\n")
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		case <-e.done:
+			return
 		}
-		size, err := strconv.Atoi(os.Getenv("REDIS_POOL_SIZE"))
+	}
+}
+
+// writePlain writes line newline terminated.
+func writePlain(w io.Writer, line string) {
+	io.WriteString(w, line)
+	if !strings.HasSuffix(line, "\n") {
+		io.WriteString(w, "\n")
+	}
+}
+
+// writeEvent writes line as a Server-Sent Event, one data field per line,
+// with its sequence number as the event id if it is known.
+func writeEvent(w io.Writer, seq int64, line string) {
+	if seq > 0 {
+		fmt.Fprintf(w, "id: %d\n", seq)
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(line, "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", l)
+	}
+	io.WriteString(w, "\n")
+}
+
+// configHandler shows the configuration in effect, without credentials.
+func (e *env) configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(e.cfg.Redacted())
+}
+
+func main() {
+	cfg, err := config.Load(os.Getenv)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	policy := ds.Policy{
+		Default: ds.Retention{
+			MaxLines: cfg.BufferSize,
+			MaxAge:   cfg.RetentionMaxAge,
+			MaxBytes: cfg.RetentionMaxBytes,
+		},
+		Tokens: make(map[string]ds.Retention),
+	}
+	for token, lines := range cfg.BufferSizes {
+		policy.Tokens[token] = ds.Retention{MaxLines: lines}
+	}
+
+	e := &env{
+		cfg:         cfg,
+		strictCount: cfg.MsgCountMode == config.StrictMsgCount,
+		maxFrame:    cfg.MaxFrameSize,
+		batchSize:   cfg.InsertBatchSize,
+		heartbeat:   TailHeartbeat,
+		done:        make(chan struct{}),
+	}
+	if len(cfg.AlertRules) > 0 {
+		e.alerts = alert.New(cfg.AlertRules)
+	}
+
+	switch cfg.Datastore {
+	case config.Redis:
+		db, err := ds.NewInRedisWithPolicy(cfg.RedisURL, policy, cfg.RedisPoolSize)
 		if err != nil {
-			size = DefaultRedisPoolSize
+			log.Fatal(err)
 		}
-		db, err := ds.NewInRedis(url, keep, size)
+		e.db = db
+	case config.S3:
+		db, err := ds.NewInS3(ds.S3Config{
+			Endpoint:      cfg.S3Endpoint,
+			Bucket:        cfg.S3Bucket,
+			Region:        cfg.S3Region,
+			Prefix:        cfg.S3Prefix,
+			AccessKey:     cfg.AWSAccessKeyID,
+			SecretKey:     cfg.AWSSecretKey,
+			BatchSize:     cfg.S3BatchSize,
+			FlushInterval: cfg.S3FlushInterval,
+		}, cfg.BufferSize)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if cfg.RetentionMaxAge > 0 || cfg.RetentionMaxBytes > 0 || len(cfg.BufferSizes) > 0 {
+			log.WithFields(log.Fields{
+				"at": "main",
+			}).Warn("$BUFFER_SIZES, $RETENTION_MAX_AGE and $RETENTION_MAX_BYTES are not enforced by the s3 datastore")
+		}
 		e.db = db
-	case "memory":
-		fallthrough
 	default:
-		db, _ := ds.NewInMemory(keep)
+		db, _ := ds.NewInMemoryWithPolicy(policy)
+		if cfg.SnapshotFile != "" {
+			if err := restoreSnapshot(db, cfg.SnapshotFile); err != nil {
+				log.WithFields(log.Fields{
+					"at":  "main",
+					"err": err,
+				}).Error("unable to restore snapshot, starting empty")
+			}
+		}
 		e.db = db
 	}
+	registerDatastoreMetrics(e.db)
 
 	var (
-		root = goji.NewMux()
-		list = goji.SubMux()
-		logs = goji.SubMux()
+		root    = goji.NewMux()
+		list    = goji.SubMux()
+		logs    = goji.SubMux()
+		entries = goji.SubMux()
+		tail    = goji.SubMux()
+		stats   = goji.SubMux()
 	)
 
 	root.HandleFunc(pat.Get("/healthcheck"), e.healthHandler)
 	root.Handle(pat.New("/logs"), logs)
+	root.Handle(pat.New("/ingest"), entries)
 	root.Handle(pat.New("/list/*"), list)
+	root.Handle(pat.New("/tail/*"), tail)
+	root.Handle(pat.New("/stats/*"), stats)
+
+	readAuth := auth.ReadAuth(cfg.ReadKeys, cfg.ReadBasicAuth, cfg.ReadBearerTokens)
+	if cfg.ReadKeys == "" && cfg.ReadBasicAuth == "" && cfg.ReadBearerTokens == "" {
+		log.WithFields(log.Fields{
+			"at": "main",
+		}).Warn("no read credentials are set, so every drain's logs can be read by anyone; set $READ_KEYS, $READ_BASIC_AUTH or $READ_BEARER_TOKENS")
+	}
 
+	list.Use(readAuth)
 	list.HandleFunc(pat.Get("/:token"), e.listHandler)
 
-	logs.Use(auth.DrainTokenAuth(os.Getenv("DRAIN_TOKENS")))
+	tail.Use(readAuth)
+	tail.HandleFunc(pat.Get("/:token"), e.tailHandler)
+
+	stats.Use(readAuth)
+	stats.HandleFunc(pat.Get("/:token/router"), e.routerStatsHandler)
+
+	// Metrics are labelled with drain tokens, so share the read credentials.
+	root.Handle(pat.Get("/metrics"), readAuth(registry))
+	root.Handle(pat.Get("/config"), readAuth(http.HandlerFunc(e.configHandler)))
+
+	logs.Use(countRejected(msgCount))
+	logs.Use(auth.DrainTokenAuth(cfg.DrainTokens))
 	logs.HandleFunc(pat.Post(""), e.logsHandler)
 
-	if err := http.ListenAndServe(listen+":"+port, root); err != nil {
+	entries.Use(countRejected(e.countEntries))
+	entries.Use(auth.DrainTokenAuth(cfg.DrainTokens))
+	entries.HandleFunc(pat.Post(""), e.ingestHandler)
+
+	tokens := auth.ParseDrainTokens(cfg.DrainTokens)
+	if cfg.SyslogTCPListen != "" {
+		s := e.newStream("tcp", cfg.SyslogTCPToken, cfg.SyslogTCPFraming, tokens)
+		go e.serveListener("tcp", func() error { return s.ListenAndServe(cfg.SyslogTCPListen) })
+	}
+	if cfg.SyslogTLSListen != "" {
+		s := e.newStream("tls", cfg.SyslogTLSToken, cfg.SyslogTLSFraming, tokens)
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cfg.SyslogTLSKeyPair}}
+		go e.serveListener("tls", func() error { return s.ListenAndServeTLS(cfg.SyslogTLSListen, tlsConfig) })
+	}
+	if cfg.SyslogUDPListen != "" {
+		s := &ingest.PacketServer{
+			Token:        cfg.SyslogUDPToken,
+			Routes:       cfg.SyslogUDPRoutes,
+			Tokens:       tokens,
+			Insert:       e.insert,
+			MaxFrameSize: e.maxFrame,
+			BatchSize:    e.batchSize,
+			Drop: func(reason string) {
+				messagesDropped.Inc("udp", reason)
+			},
+		}
+		e.listeners = append(e.listeners, s)
+		go e.serveListener("udp", func() error { return s.ListenAndServe(cfg.SyslogUDPListen) })
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Listen + ":" + strconv.Itoa(cfg.Port),
+		Handler: root,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("unable to start server")
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	log.WithFields(log.Fields{
+		"at":     "shutdown",
+		"signal": <-sig,
+	}).Info("shutting down")
+	e.shutdown(srv)
+}
+
+// shutdown stops accepting requests and delivers queued alerts, waiting for
+// both no longer than the shutdown timeout, then closes the datastore.
+func (e *env) shutdown(srv *http.Server) {
+	close(e.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
 		log.WithFields(log.Fields{
+			"at":  "shutdown",
 			"err": err,
-		}).Fatal("unable to start server")
+		}).Error("requests still in flight")
+	}
+
+	for _, l := range e.listeners {
+		l.Close()
+	}
+	if e.alerts != nil {
+		if err := e.alerts.Close(ctx); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "shutdown",
+				"err": err,
+			}).Error("abandoned undelivered alerts")
+		}
+	}
+	if err := e.db.Close(); err != nil {
+		log.WithFields(log.Fields{
+			"at":  "shutdown",
+			"err": err,
+		}).Error("unable to close datastore")
+	}
+
+	if db, ok := e.db.(*ds.MemoryDB); ok && e.cfg.SnapshotFile != "" {
+		if err := saveSnapshot(db, e.cfg.SnapshotFile); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "shutdown",
+				"err": err,
+			}).Error("unable to save snapshot")
+		}
+	}
+}
+
+// newStream creates a syslog stream listener storing messages through the
+// same path as drain requests.
+func (e *env) newStream(name, token string, framing syslog.Framing, tokens auth.DrainTokens) *ingest.StreamServer {
+	s := &ingest.StreamServer{
+		Token:        token,
+		Tokens:       tokens,
+		Framing:      framing,
+		Insert:       e.insert,
+		MaxFrameSize: e.maxFrame,
+		BatchSize:    e.batchSize,
+		IdleTimeout:  e.cfg.SyslogIdleTimeout,
+		Drop: func(reason string) {
+			messagesDropped.Inc(name, reason)
+		},
+	}
+	e.listeners = append(e.listeners, s)
+	return s
+}
+
+// serveListener runs a syslog listener, exiting if it fails.
+func (e *env) serveListener(name string, serve func() error) {
+	if err := serve(); err != nil {
+		log.WithFields(log.Fields{
+			"listener": name,
+			"err":      err,
+		}).Fatal("unable to start syslog listener")
+	}
+}
+
+// restoreSnapshot restores db from the snapshot at path, if there is one.
+func restoreSnapshot(db *ds.MemoryDB, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return db.Restore(f)
+}
+
+// saveSnapshot writes a snapshot of db to path, replacing any earlier one only
+// once it has been written in full.
+func saveSnapshot(db *ds.MemoryDB, path string) error {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := db.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
+	return os.Rename(path+".tmp", path)
 }
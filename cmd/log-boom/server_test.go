@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ds "github.com/heroku/log-boom/datastore"
+	"goji.io"
+	"goji.io/pat"
+)
+
+// hubDB is a MemoryDB whose subscriptions come from hub, so tests can see
+// them end. beforeQuery, if set, is run when the tail snapshot is taken.
+type hubDB struct {
+	*ds.MemoryDB
+	hub         *ds.Hub
+	subs        chan *ds.Subscription
+	beforeQuery func()
+}
+
+func (db *hubDB) Subscribe(token string) (*ds.Subscription, error) {
+	s := db.hub.Subscribe(token)
+	db.subs <- s
+	return s, nil
+}
+
+func (db *hubDB) Query(token string, q ds.Query) (*ds.Page, error) {
+	if db.beforeQuery != nil {
+		db.beforeQuery()
+	}
+	return db.MemoryDB.Query(token, q)
+}
+
+func newHubDB(t *testing.T, size int) *hubDB {
+	mem, err := ds.NewInMemory(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &hubDB{MemoryDB: mem, hub: ds.NewHub(size), subs: make(chan *ds.Subscription, 10)}
+}
+
+// startTail starts serving tails from db, sending heartbeats every
+// heartbeat, and requests the tail of token. stop ends both.
+func startTail(t *testing.T, db ds.Datastore, heartbeat time.Duration, token string, header http.Header) (resp *http.Response, r *bufio.Reader, stop func()) {
+	e := &env{db: db, heartbeat: heartbeat, done: make(chan struct{})}
+	mux := goji.NewMux()
+	mux.HandleFunc(pat.Get("/tail/:token"), e.tailHandler)
+	srv := httptest.NewServer(mux)
+
+	req, err := http.NewRequest("GET", srv.URL+"/tail/"+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range header {
+		req.Header.Set(name, header.Get(name))
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, bufio.NewReader(resp.Body), func() {
+		resp.Body.Close()
+		close(e.done)
+		srv.Close()
+	}
+}
+
+// readUntil reads r until what has been read ends with want.
+func readUntil(t *testing.T, r *bufio.Reader, want string) string {
+	var got string
+	for !strings.HasSuffix(got, want) {
+		line, err := r.ReadString('\n')
+		got += line
+		if err != nil {
+			t.Fatalf("read %q, want it to end with %q: %v", got, want, err)
+		}
+	}
+	return got
+}
+
+func TestTailHandler(t *testing.T) {
+	db := newHubDB(t, 10)
+	mustInsert(t, db.MemoryDB, "t", "a", "b")
+
+	for _, tt := range []struct {
+		name     string
+		header   http.Header
+		snapshot string
+		live     string
+	}{
+		{
+			"event stream",
+			http.Header{"Accept": {"text/event-stream"}},
+			"id: 1\ndata: a\n\nid: 2\ndata: b\n\nid: 2\nevent: live\ndata: 2\n\n",
+			"id: 3\ndata: c\n\n",
+		},
+		{
+			"resumed event stream",
+			http.Header{"Accept": {"text/event-stream"}, "Last-Event-Id": {"1"}},
+			"id: 2\ndata: b\n\nid: 2\nevent: live\ndata: 1\n\n",
+			"id: 3\ndata: c\n\n",
+		},
+		{"plain text", nil, "a\nb\n", "c\n"},
+	} {
+		resp, r, stop := startTail(t, db, 20*time.Millisecond, "t", tt.header)
+		sub := <-db.subs
+
+		if got := readUntil(t, r, tt.snapshot); got != tt.snapshot {
+			t.Errorf("%s: snapshot %q, want %q", tt.name, got, tt.snapshot)
+		}
+		db.hub.Publish("t", 3, []string{"c"})
+		// Lines already in the snapshot, as when published between
+		// subscribing and taking it, are skipped.
+		db.hub.Publish("t", 2, []string{"b"})
+		db.hub.Publish("t", 4, []string{"d"})
+		live := readUntil(t, r, tt.live)
+		if sse := tt.header != nil; sse {
+			if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+				t.Errorf("%s: Content-Type = %q", tt.name, resp.Header.Get("Content-Type"))
+			}
+			// Only heartbeats may come before the line.
+			if strings.Trim(strings.TrimSuffix(live, tt.live), ":\n") != "" {
+				t.Errorf("%s: live %q, want %q", tt.name, live, tt.live)
+			}
+		} else if live != tt.live {
+			t.Errorf("%s: live %q, want %q", tt.name, live, tt.live)
+		}
+		readUntil(t, r, strings.Replace(strings.Replace(tt.live, "3", "4", -1), "c", "d", -1))
+
+		// The subscription ends when the client goes away.
+		resp.Body.Close()
+		select {
+		case <-waitClosed(sub):
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: subscription not closed after the client disconnected", tt.name)
+		}
+		stop()
+	}
+}
+
+func waitClosed(s *ds.Subscription) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range s.C {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func TestTailHandlerHeartbeat(t *testing.T) {
+	db := newHubDB(t, 10)
+	_, r, stop := startTail(t, db, 20*time.Millisecond, "t", http.Header{"Accept": {"text/event-stream"}})
+	defer stop()
+
+	readUntil(t, r, "event: live\ndata: 0\n\n")
+	readUntil(t, r, ":\n\n")
+}
+
+// TestTailHandlerSlowConsumer checks a client which falls behind is sent an
+// error event and disconnected.
+func TestTailHandlerSlowConsumer(t *testing.T) {
+	db := newHubDB(t, 1)
+	db.beforeQuery = func() {
+		db.hub.Publish("t", 2, []string{"1", "2"})
+	}
+	_, r, stop := startTail(t, db, time.Hour, "t", http.Header{"Accept": {"text/event-stream"}})
+	defer stop()
+
+	readUntil(t, r, "event: live\ndata: 0\n\n")
+	got := readUntil(t, r, "event: error\ndata: slow consumer\n\n")
+	if want := "id: 1\ndata: 1\n\nevent: error\ndata: slow consumer\n\n"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	if line, err := r.ReadString('\n'); err == nil {
+		t.Errorf("read %q after the error event, want the stream to end", line)
+	}
+}
+
+func TestTailHandlerNotSubscriber(t *testing.T) {
+	db := newHubDB(t, 10)
+	resp, _, stop := startTail(t, struct{ ds.Datastore }{db}, time.Hour, "t", nil)
+	defer stop()
+
+	if resp.StatusCode != 501 {
+		t.Errorf("status = %d, want 501", resp.StatusCode)
+	}
+}
+
+func mustInsert(t *testing.T, db ds.Datastore, token string, lines ...string) {
+	if _, err := db.Insert(token, lines); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+}
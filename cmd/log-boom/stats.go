@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/heroku/log-boom/datastore"
+	"github.com/heroku/log-boom/syslog"
+	"goji.io/pat"
+)
+
+// DefaultTopPaths is the default number of paths in router stats.
+const DefaultTopPaths = 10
+
+// routerStats summarises the Heroku router lines of a drain.
+type routerStats struct {
+	Requests int            `json:"requests"`
+	Since    *time.Time     `json:"since,omitempty"`
+	Until    *time.Time     `json:"until,omitempty"`
+	Statuses map[string]int `json:"statuses"`
+	Errors   map[string]int `json:"errors"`
+	Service  percentiles    `json:"service_ms"`
+	Connect  percentiles    `json:"connect_ms"`
+	Paths    []pathCount    `json:"paths"`
+}
+
+// percentiles of a duration, in milliseconds.
+type percentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+type pathCount struct {
+	Path     string `json:"path"`
+	Requests int    `json:"requests"`
+}
+
+func (e *env) routerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	token := pat.Param(r, "token")
+
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "stats",
+			"err": err,
+		}).Error("unable to parse query")
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+	top := DefaultTopPaths
+	if n := r.URL.Query().Get("top"); n != "" {
+		if top, err = strconv.Atoi(n); err != nil || top < 0 {
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+	}
+
+	lines, err := e.db.List(token)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "stats",
+			"err": err,
+		}).Error("could find stored logs")
+		if err == ds.ErrNoSuchToken {
+			http.Error(w, http.StatusText(404), 404)
+		} else {
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	json.NewEncoder(w).Encode(summarizeRouter(lines, f, top))
+}
+
+// summarizeRouter summarises the router lines among lines matching f,
+// including the top most requested paths. Query strings are not part of a
+// path.
+func summarizeRouter(lines []string, f ds.Filter, top int) *routerStats {
+	var (
+		stats = &routerStats{
+			Statuses: make(map[string]int),
+			Errors:   make(map[string]int),
+		}
+		paths   = make(map[string]int)
+		service []time.Duration
+		connect []time.Duration
+	)
+
+	for _, line := range lines {
+		m, err := syslog.Parse([]byte(line))
+		if err != nil || !syslog.IsRouter(m) || !f.MatchMessage(m) {
+			continue
+		}
+		rl, err := syslog.ParseRouter(m.Message)
+		if err != nil {
+			continue
+		}
+
+		stats.Requests++
+		if ts := m.Timestamp; !ts.IsZero() {
+			if stats.Since == nil || ts.Before(*stats.Since) {
+				stats.Since = &ts
+			}
+			if stats.Until == nil || ts.After(*stats.Until) {
+				stats.Until = &ts
+			}
+		}
+		if rl.Status != 0 {
+			stats.Statuses[strconv.Itoa(rl.Status)]++
+		}
+		if rl.Code != "" {
+			stats.Errors[rl.Code]++
+		}
+		if i := strings.IndexByte(rl.Path, '?'); i >= 0 {
+			rl.Path = rl.Path[:i]
+		}
+		paths[rl.Path]++
+		if rl.HasService {
+			service = append(service, rl.Service)
+		}
+		if rl.HasConnect {
+			connect = append(connect, rl.Connect)
+		}
+	}
+
+	stats.Service = percentilesOf(service)
+	stats.Connect = percentilesOf(connect)
+
+	stats.Paths = make([]pathCount, 0, len(paths))
+	for path, n := range paths {
+		stats.Paths = append(stats.Paths, pathCount{Path: path, Requests: n})
+	}
+	sort.Sort(byRequests(stats.Paths))
+	if len(stats.Paths) > top {
+		stats.Paths = stats.Paths[:top]
+	}
+	return stats
+}
+
+// percentilesOf returns the nearest rank percentiles of d, which it sorts.
+func percentilesOf(d []time.Duration) percentiles {
+	if len(d) == 0 {
+		return percentiles{}
+	}
+	sort.Sort(durations(d))
+
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(d)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return float64(d[i]) / float64(time.Millisecond)
+	}
+	return percentiles{
+		P50: rank(.50),
+		P95: rank(.95),
+		P99: rank(.99),
+		Max: float64(d[len(d)-1]) / float64(time.Millisecond),
+	}
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// byRequests orders paths most requested first, then by path.
+type byRequests []pathCount
+
+func (p byRequests) Len() int      { return len(p) }
+func (p byRequests) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byRequests) Less(i, j int) bool {
+	if p[i].Requests != p[j].Requests {
+		return p[i].Requests > p[j].Requests
+	}
+	return p[i].Path < p[j].Path
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	ds "github.com/heroku/log-boom/datastore"
+)
+
+func TestPercentilesOf(t *testing.T) {
+	var d []time.Duration
+	for i := 100; i >= 1; i-- {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	if got, want := percentilesOf(d), (percentiles{P50: 50, P95: 95, P99: 99, Max: 100}); got != want {
+		t.Errorf("percentilesOf(1..100ms) = %+v, want %+v", got, want)
+	}
+
+	if got, want := percentilesOf([]time.Duration{3 * time.Millisecond}), (percentiles{P50: 3, P95: 3, P99: 3, Max: 3}); got != want {
+		t.Errorf("percentilesOf(3ms) = %+v, want %+v", got, want)
+	}
+	if got := percentilesOf(nil); got != (percentiles{}) {
+		t.Errorf("percentilesOf(nil) = %+v, want zero", got)
+	}
+}
+
+func TestSummarizeRouter(t *testing.T) {
+	router := func(timings string) string {
+		return fmt.Sprintf("<158>1 2026-10-17T00:00:00+00:00 host heroku router - at=info method=GET path=\"/a?x=1\" dyno=web.1 %s status=200 bytes=1", timings)
+	}
+	lines := []string{
+		router("connect=1ms service=100ms"),
+		router("connect=3ms service=300ms"),
+		// Requests which never reached a dyno don't pull timings down.
+		"<158>1 2026-10-17T00:00:01+00:00 host heroku router - at=error code=H10 desc=\"App crashed\" method=GET path=\"/b\" dyno= connect= service= status=503 bytes=",
+		"<158>1 2026-10-17T00:00:02+00:00 host heroku router - at=error code=H10 desc=\"App crashed\" method=GET path=\"/b\" status=503",
+		"<190>1 2026-10-17T00:00:00+00:00 host app web.1 - not a router line",
+	}
+
+	stats := summarizeRouter(lines, ds.Filter{}, 10)
+	if stats.Requests != 4 {
+		t.Errorf("Requests = %d, want 4", stats.Requests)
+	}
+	if want := (percentiles{P50: 100, P95: 300, P99: 300, Max: 300}); stats.Service != want {
+		t.Errorf("Service = %+v, want %+v", stats.Service, want)
+	}
+	if want := (percentiles{P50: 1, P95: 3, P99: 3, Max: 3}); stats.Connect != want {
+		t.Errorf("Connect = %+v, want %+v", stats.Connect, want)
+	}
+	if stats.Statuses["200"] != 2 || stats.Statuses["503"] != 2 || stats.Errors["H10"] != 2 {
+		t.Errorf("Statuses = %v, Errors = %v", stats.Statuses, stats.Errors)
+	}
+	if fmt.Sprint(stats.Paths) != "[{/a 2} {/b 2}]" {
+		t.Errorf("Paths = %v", stats.Paths)
+	}
+}
@@ -0,0 +1,520 @@
+// Package config loads and validates the settings of the log-boom server
+// from the environment and an optional configuration file.
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heroku/log-boom/alert"
+	"github.com/heroku/log-boom/ingest"
+	"github.com/heroku/log-boom/syslog"
+)
+
+const (
+	// DefaultRedisPoolSize is the default pool size (defaults to 4).
+	DefaultRedisPoolSize = 4
+
+	// DefaultBufferSize is the default size of the ring bugger in log lines.
+	DefaultBufferSize = 1500
+
+	// DefaultS3BatchSize is the default number of lines per S3 object.
+	DefaultS3BatchSize = 500
+
+	// DefaultS3FlushInterval is the default longest time lines are held before
+	// being written to S3.
+	DefaultS3FlushInterval = 10 * time.Second
+
+	// DefaultS3Region is the default region of the S3 bucket.
+	DefaultS3Region = "us-east-1"
+
+	// DefaultInsertBatchSize is the default largest number of lines from a
+	// drain request handed to the datastore at once.
+	DefaultInsertBatchSize = 100
+
+	// DefaultShutdownTimeout is the default longest wait for in-flight
+	// requests to finish on shutdown, within the 30 seconds Heroku allows
+	// after SIGTERM.
+	DefaultShutdownTimeout = 25 * time.Second
+
+	// StrictMsgCount rejects drain requests whose Logplex-Msg-Count header
+	// does not match the number of frames in the body.
+	StrictMsgCount = "strict"
+
+	// LenientMsgCount accepts drain requests whose Logplex-Msg-Count header
+	// does not match the number of frames in the body, but counts them.
+	LenientMsgCount = "lenient"
+)
+
+// Datastores understood by the server.
+const (
+	Memory = "memory"
+	Redis  = "redis"
+	S3     = "s3"
+)
+
+// Config holds the settings of the server. Each field is set from the
+// environment variable named in its comment, falling back to the
+// configuration file named by CONFIG_FILE and then to a default.
+type Config struct {
+	File string // CONFIG_FILE
+
+	Listen          string        // LISTEN
+	Port            int           // PORT
+	ShutdownTimeout time.Duration // SHUTDOWN_TIMEOUT
+
+	Datastore         string         // DATASTORE
+	SnapshotFile      string         // MEMORY_SNAPSHOT_FILE
+	BufferSize        int            // BUFFER_SIZE
+	BufferSizes       map[string]int // BUFFER_SIZES
+	RetentionMaxAge   time.Duration  // RETENTION_MAX_AGE
+	RetentionMaxBytes int            // RETENTION_MAX_BYTES
+	MaxFrameSize      int            // MAX_FRAME_SIZE
+	InsertBatchSize   int            // INSERT_BATCH_SIZE
+	MsgCountMode      string         // MSG_COUNT_MODE
+	LogsFraming       syslog.Framing // LOGS_FRAMING
+	AlertRules        []alert.Rule   // ALERT_RULES
+	DrainTokens       string         // DRAIN_TOKENS
+	ReadKeys          string         // READ_KEYS
+	ReadBasicAuth     string         // READ_BASIC_AUTH
+	ReadBearerTokens  string         // READ_BEARER_TOKENS
+	RedisURL          *url.URL       // REDIS_URL
+	RedisPoolSize     int            // REDIS_POOL_SIZE
+	S3Bucket          string         // S3_BUCKET
+	S3Region          string         // S3_REGION
+	S3Endpoint        *url.URL       // S3_ENDPOINT
+	S3Prefix          string         // S3_PREFIX
+	S3BatchSize       int            // S3_BATCH_SIZE
+	S3FlushInterval   time.Duration  // S3_FLUSH_INTERVAL
+	AWSAccessKeyID    string         // AWS_ACCESS_KEY_ID
+	AWSSecretKey      string         // AWS_SECRET_ACCESS_KEY
+
+	SyslogTCPListen   string          // SYSLOG_TCP_LISTEN
+	SyslogTCPToken    string          // SYSLOG_TCP_TOKEN
+	SyslogTCPFraming  syslog.Framing  // SYSLOG_TCP_FRAMING
+	SyslogTLSListen   string          // SYSLOG_TLS_LISTEN
+	SyslogTLSToken    string          // SYSLOG_TLS_TOKEN
+	SyslogTLSFraming  syslog.Framing  // SYSLOG_TLS_FRAMING
+	SyslogTLSCert     string          // SYSLOG_TLS_CERT
+	SyslogTLSKey      string          // SYSLOG_TLS_KEY
+	SyslogTLSKeyPair  tls.Certificate // loaded from SYSLOG_TLS_CERT and SYSLOG_TLS_KEY
+	SyslogIdleTimeout time.Duration   // SYSLOG_IDLE_TIMEOUT
+	SyslogUDPListen   string          // SYSLOG_UDP_LISTEN
+	SyslogUDPToken    string          // SYSLOG_UDP_TOKEN
+	SyslogUDPRoutes   *ingest.Routes  // SYSLOG_UDP_ROUTES
+
+	settings map[string]string // the raw values, by environment variable name
+}
+
+// settings are the names of every setting, as environment variables.
+var settings = []string{
+	"LISTEN", "PORT", "SHUTDOWN_TIMEOUT",
+	"DATASTORE", "MEMORY_SNAPSHOT_FILE", "BUFFER_SIZE", "BUFFER_SIZES", "RETENTION_MAX_AGE", "RETENTION_MAX_BYTES",
+	"MAX_FRAME_SIZE", "INSERT_BATCH_SIZE", "MSG_COUNT_MODE", "LOGS_FRAMING", "ALERT_RULES",
+	"DRAIN_TOKENS", "READ_KEYS", "READ_BASIC_AUTH", "READ_BEARER_TOKENS",
+	"REDIS_URL", "REDIS_POOL_SIZE",
+	"S3_BUCKET", "S3_REGION", "S3_ENDPOINT", "S3_PREFIX", "S3_BATCH_SIZE", "S3_FLUSH_INTERVAL",
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY",
+	"SYSLOG_TCP_LISTEN", "SYSLOG_TCP_TOKEN", "SYSLOG_TCP_FRAMING",
+	"SYSLOG_TLS_LISTEN", "SYSLOG_TLS_TOKEN", "SYSLOG_TLS_FRAMING", "SYSLOG_TLS_CERT", "SYSLOG_TLS_KEY",
+	"SYSLOG_IDLE_TIMEOUT",
+	"SYSLOG_UDP_LISTEN", "SYSLOG_UDP_TOKEN", "SYSLOG_UDP_ROUTES",
+}
+
+// Errors lists every invalid setting found by Load.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Load reads the configuration using getenv, usually os.Getenv. Settings in
+// the environment take precedence over those in the file named by
+// CONFIG_FILE. Every invalid setting is reported in the returned Errors.
+func Load(getenv func(string) string) (*Config, error) {
+	c := &Config{
+		File:     getenv("CONFIG_FILE"),
+		settings: make(map[string]string),
+	}
+
+	var errs Errors
+	if c.File != "" {
+		file, err := parseFile(c.File)
+		if err != nil {
+			return nil, Errors{fmt.Errorf("CONFIG_FILE %s: %v", c.File, err)}
+		}
+		for name, value := range file {
+			c.settings[name] = value
+		}
+	}
+	for _, name := range settings {
+		if value := getenv(name); value != "" {
+			c.settings[name] = value
+		}
+	}
+
+	p := parser{settings: c.settings, errs: &errs}
+
+	c.Listen = p.str("LISTEN", "")
+	c.Port = p.integer("PORT", 0)
+	if _, ok := c.settings["PORT"]; !ok {
+		errs = append(errs, fmt.Errorf("PORT must be set"))
+	} else if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be between 1 and 65535"))
+	}
+
+	c.ShutdownTimeout = p.duration("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout)
+
+	c.Datastore = p.oneOf("DATASTORE", Memory, Memory, Redis, S3)
+	c.SnapshotFile = p.str("MEMORY_SNAPSHOT_FILE", "")
+	if c.SnapshotFile != "" && c.Datastore != Memory {
+		errs = append(errs, fmt.Errorf("MEMORY_SNAPSHOT_FILE is only supported by the memory datastore"))
+	}
+	c.BufferSize = p.positive("BUFFER_SIZE", DefaultBufferSize)
+	c.BufferSizes = p.sizes("BUFFER_SIZES")
+	c.RetentionMaxAge = p.duration("RETENTION_MAX_AGE", 0)
+	c.RetentionMaxBytes = p.integer("RETENTION_MAX_BYTES", 0)
+	if c.RetentionMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_MAX_BYTES must not be negative"))
+	}
+	c.MaxFrameSize = p.positive("MAX_FRAME_SIZE", syslog.DefaultMaxFrameSize)
+	c.InsertBatchSize = p.positive("INSERT_BATCH_SIZE", DefaultInsertBatchSize)
+	c.MsgCountMode = p.oneOf("MSG_COUNT_MODE", LenientMsgCount, LenientMsgCount, StrictMsgCount)
+	c.LogsFraming = p.framing("LOGS_FRAMING", syslog.OctetCounted)
+
+	if rules := p.str("ALERT_RULES", ""); rules != "" {
+		var err error
+		if c.AlertRules, err = alert.ParseRules([]byte(rules)); err != nil {
+			errs = append(errs, fmt.Errorf("ALERT_RULES is invalid: %v", err))
+		}
+	}
+
+	c.DrainTokens = p.str("DRAIN_TOKENS", "")
+	c.ReadKeys = p.str("READ_KEYS", "")
+	c.ReadBasicAuth = p.str("READ_BASIC_AUTH", "")
+	c.ReadBearerTokens = p.str("READ_BEARER_TOKENS", "")
+
+	c.RedisURL = p.url("REDIS_URL", "redis")
+	c.RedisPoolSize = p.positive("REDIS_POOL_SIZE", DefaultRedisPoolSize)
+	if c.Datastore == Redis && c.RedisURL == nil {
+		errs = append(errs, fmt.Errorf("REDIS_URL must be set to use the redis datastore"))
+	}
+
+	c.S3Bucket = p.str("S3_BUCKET", "")
+	c.S3Region = p.str("S3_REGION", DefaultS3Region)
+	c.S3Endpoint = p.url("S3_ENDPOINT", "http", "https")
+	if c.S3Endpoint == nil {
+		c.S3Endpoint = &url.URL{Scheme: "https", Host: "s3." + c.S3Region + ".amazonaws.com"}
+	}
+	c.S3Prefix = p.str("S3_PREFIX", "")
+	c.S3BatchSize = p.positive("S3_BATCH_SIZE", DefaultS3BatchSize)
+	c.S3FlushInterval = p.duration("S3_FLUSH_INTERVAL", DefaultS3FlushInterval)
+	c.AWSAccessKeyID = p.str("AWS_ACCESS_KEY_ID", "")
+	c.AWSSecretKey = p.str("AWS_SECRET_ACCESS_KEY", "")
+	if c.Datastore == S3 {
+		for _, name := range []string{"S3_BUCKET", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+			if c.settings[name] == "" {
+				errs = append(errs, fmt.Errorf("%s must be set to use the s3 datastore", name))
+			}
+		}
+	}
+
+	c.SyslogTCPListen = p.addr("SYSLOG_TCP_LISTEN")
+	c.SyslogTCPToken = p.str("SYSLOG_TCP_TOKEN", "")
+	c.SyslogTCPFraming = p.framing("SYSLOG_TCP_FRAMING", syslog.DetectFraming)
+	c.SyslogTLSListen = p.addr("SYSLOG_TLS_LISTEN")
+	c.SyslogTLSToken = p.str("SYSLOG_TLS_TOKEN", "")
+	c.SyslogTLSFraming = p.framing("SYSLOG_TLS_FRAMING", syslog.DetectFraming)
+	c.SyslogTLSCert = p.str("SYSLOG_TLS_CERT", "")
+	c.SyslogTLSKey = p.str("SYSLOG_TLS_KEY", "")
+	if c.SyslogTLSListen != "" {
+		if c.SyslogTLSCert == "" || c.SyslogTLSKey == "" {
+			errs = append(errs, fmt.Errorf("SYSLOG_TLS_CERT and SYSLOG_TLS_KEY must be set to use SYSLOG_TLS_LISTEN"))
+		} else {
+			var err error
+			if c.SyslogTLSKeyPair, err = tls.LoadX509KeyPair(c.SyslogTLSCert, c.SyslogTLSKey); err != nil {
+				errs = append(errs, fmt.Errorf("SYSLOG_TLS_CERT and SYSLOG_TLS_KEY are invalid: %v", err))
+			}
+		}
+	}
+	c.SyslogIdleTimeout = p.duration("SYSLOG_IDLE_TIMEOUT", 0)
+	c.SyslogUDPListen = p.addr("SYSLOG_UDP_LISTEN")
+	c.SyslogUDPToken = p.str("SYSLOG_UDP_TOKEN", "")
+	if routes := p.str("SYSLOG_UDP_ROUTES", ""); routes != "" {
+		var err error
+		if c.SyslogUDPRoutes, err = ingest.ParseRoutes(routes); err != nil {
+			errs = append(errs, fmt.Errorf("SYSLOG_UDP_ROUTES is invalid: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// parser reads typed settings, recording an error for each invalid one.
+type parser struct {
+	settings map[string]string
+	errs     *Errors
+}
+
+func (p parser) fail(format string, args ...interface{}) {
+	*p.errs = append(*p.errs, fmt.Errorf(format, args...))
+}
+
+func (p parser) str(name, fallback string) string {
+	if v, ok := p.settings[name]; ok {
+		return v
+	}
+	return fallback
+}
+
+func (p parser) integer(name string, fallback int) int {
+	v, ok := p.settings[name]
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail("%s must be a whole number, not %q", name, v)
+		return fallback
+	}
+	return n
+}
+
+func (p parser) positive(name string, fallback int) int {
+	n := p.integer(name, fallback)
+	if n <= 0 {
+		p.fail("%s must be greater than 0", name)
+		return fallback
+	}
+	return n
+}
+
+func (p parser) duration(name string, fallback time.Duration) time.Duration {
+	v, ok := p.settings[name]
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		p.fail("%s must be a duration such as 10s or 24h, not %q", name, v)
+		return fallback
+	}
+	return d
+}
+
+func (p parser) oneOf(name, fallback string, values ...string) string {
+	v, ok := p.settings[name]
+	if !ok {
+		return fallback
+	}
+	for _, allowed := range values {
+		if v == allowed {
+			return v
+		}
+	}
+	p.fail("%s must be one of %s, not %q", name, strings.Join(values, ", "), v)
+	return fallback
+}
+
+func (p parser) framing(name string, fallback syslog.Framing) syslog.Framing {
+	v, ok := p.settings[name]
+	if !ok {
+		return fallback
+	}
+	f, err := syslog.ParseFraming(v)
+	if err != nil {
+		p.fail("%s must be one of octet-counted, non-transparent, auto, not %q", name, v)
+		return fallback
+	}
+	return f
+}
+
+func (p parser) url(name string, schemes ...string) *url.URL {
+	v, ok := p.settings[name]
+	if !ok {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err == nil && u.Host != "" {
+		for _, scheme := range schemes {
+			if u.Scheme == scheme {
+				return u
+			}
+		}
+	}
+	p.fail("%s must be a %s:// url", name, strings.Join(schemes, ":// or "))
+	return nil
+}
+
+func (p parser) addr(name string) string {
+	v, ok := p.settings[name]
+	if !ok {
+		return ""
+	}
+	if _, port, err := net.SplitHostPort(v); err != nil || port == "" {
+		p.fail("%s must be a host:port address such as :6514, not %q", name, v)
+		return ""
+	}
+	return v
+}
+
+// sizes parses comma separated "token:lines" pairs.
+func (p parser) sizes(name string) map[string]int {
+	sizes := make(map[string]int)
+	for _, pair := range strings.Split(p.settings[name], ",") {
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			p.fail("%s must be token:lines pairs, not %q", name, pair)
+			continue
+		}
+		lines, err := strconv.Atoi(pair[i+1:])
+		if err != nil || lines <= 0 {
+			p.fail("%s has an invalid number of lines in %q", name, pair)
+			continue
+		}
+		sizes[pair[:i]] = lines
+	}
+	return sizes
+}
+
+// Redacted returns the settings in effect, by environment variable name, with
+// credentials replaced by "REDACTED".
+func (c *Config) Redacted() map[string]interface{} {
+	const redacted = "REDACTED"
+
+	v := map[string]interface{}{
+		"CONFIG_FILE":           c.File,
+		"LISTEN":                c.Listen,
+		"PORT":                  c.Port,
+		"SHUTDOWN_TIMEOUT":      c.ShutdownTimeout.String(),
+		"MEMORY_SNAPSHOT_FILE":  c.SnapshotFile,
+		"DATASTORE":             c.Datastore,
+		"BUFFER_SIZE":           c.BufferSize,
+		"BUFFER_SIZES":          redactSizes(c.BufferSizes),
+		"RETENTION_MAX_AGE":     c.RetentionMaxAge.String(),
+		"RETENTION_MAX_BYTES":   c.RetentionMaxBytes,
+		"MAX_FRAME_SIZE":        c.MaxFrameSize,
+		"INSERT_BATCH_SIZE":     c.InsertBatchSize,
+		"MSG_COUNT_MODE":        c.MsgCountMode,
+		"LOGS_FRAMING":          c.LogsFraming.String(),
+		"ALERT_RULES":           redactRules(c.AlertRules),
+		"DRAIN_TOKENS":          redactList(c.DrainTokens),
+		"READ_KEYS":             redactList(c.ReadKeys),
+		"READ_BASIC_AUTH":       redactPairs(c.ReadBasicAuth, strings.Index),
+		"READ_BEARER_TOKENS":    redactList(c.ReadBearerTokens),
+		"REDIS_URL":             "",
+		"REDIS_POOL_SIZE":       c.RedisPoolSize,
+		"S3_BUCKET":             c.S3Bucket,
+		"S3_REGION":             c.S3Region,
+		"S3_ENDPOINT":           c.S3Endpoint.String(),
+		"S3_PREFIX":             c.S3Prefix,
+		"S3_BATCH_SIZE":         c.S3BatchSize,
+		"S3_FLUSH_INTERVAL":     c.S3FlushInterval.String(),
+		"AWS_ACCESS_KEY_ID":     c.AWSAccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": "",
+		"SYSLOG_TCP_LISTEN":     c.SyslogTCPListen,
+		"SYSLOG_TCP_TOKEN":      redactList(c.SyslogTCPToken),
+		"SYSLOG_TCP_FRAMING":    c.SyslogTCPFraming.String(),
+		"SYSLOG_TLS_LISTEN":     c.SyslogTLSListen,
+		"SYSLOG_TLS_TOKEN":      redactList(c.SyslogTLSToken),
+		"SYSLOG_TLS_FRAMING":    c.SyslogTLSFraming.String(),
+		"SYSLOG_TLS_CERT":       c.SyslogTLSCert,
+		"SYSLOG_TLS_KEY":        c.SyslogTLSKey,
+		"SYSLOG_IDLE_TIMEOUT":   c.SyslogIdleTimeout.String(),
+		"SYSLOG_UDP_LISTEN":     c.SyslogUDPListen,
+		"SYSLOG_UDP_TOKEN":      redactList(c.SyslogUDPToken),
+		"SYSLOG_UDP_ROUTES":     redactPairs(c.settings["SYSLOG_UDP_ROUTES"], strings.LastIndex),
+	}
+	if c.RedisURL != nil {
+		u := *c.RedisURL
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		v["REDIS_URL"] = u.String()
+	}
+	if c.AWSSecretKey != "" {
+		v["AWS_SECRET_ACCESS_KEY"] = redacted
+	}
+	return v
+}
+
+// redactList redacts every item of a comma separated list.
+func redactList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item != "" {
+			items = append(items, "REDACTED")
+		}
+	}
+	return items
+}
+
+// redactPairs redacts the values of comma separated "name:value" pairs, split
+// at the ":" found by index as when the setting is parsed: the first for
+// passwords, which may contain one, and the last for routes, whose IPv6
+// sources do.
+func redactPairs(s string, index func(s, sep string) int) []string {
+	pairs := []string{}
+	for _, pair := range strings.Split(s, ",") {
+		if i := index(pair, ":"); i > 0 {
+			pairs = append(pairs, pair[:i]+":REDACTED")
+		}
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// redactSizes redacts the drain tokens of "token:lines" sizes, keeping the
+// sizes.
+func redactSizes(sizes map[string]int) []string {
+	redacted := []string{}
+	for _, lines := range sizes {
+		redacted = append(redacted, "REDACTED:"+strconv.Itoa(lines))
+	}
+	sort.Strings(redacted)
+	return redacted
+}
+
+// redactRules describes rules without their drain tokens and with only the
+// hosts of their webhooks, whose paths often embed credentials.
+func redactRules(rules []alert.Rule) []map[string]interface{} {
+	described := []map[string]interface{}{}
+	for _, r := range rules {
+		hooks := make([]string, 0, len(r.Webhooks))
+		for _, hook := range r.Webhooks {
+			if u, err := url.Parse(hook); err == nil {
+				hooks = append(hooks, u.Scheme+"://"+u.Host+"/REDACTED")
+			}
+		}
+		d := map[string]interface{}{
+			"name":      r.Name,
+			"tokens":    redactList(strings.Join(r.Tokens, ",")),
+			"codes":     r.Codes,
+			"threshold": r.Threshold,
+			"window":    r.Window.String(),
+			"cooldown":  r.Cooldown.String(),
+			"webhooks":  hooks,
+		}
+		if r.Regexp != nil {
+			d["regexp"] = r.Regexp.String()
+		}
+		described = append(described, d)
+	}
+	return described
+}
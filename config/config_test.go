@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	env := map[string]string{
+		"PORT":               "5000",
+		"DRAIN_TOKENS":       "d.secret1,d.secret2,d.secret3",
+		"BUFFER_SIZES":       "d.secret1:50000",
+		"READ_KEYS":          "d.secret2:key1",
+		"READ_BASIC_AUTH":    "user:password1",
+		"READ_BEARER_TOKENS": "bearer1",
+		"SYSLOG_UDP_ROUTES":  "2001:db8::/32:d.secret4,nginx:d.secret5",
+		"ALERT_RULES":        `[{"name": "r", "codes": ["H12"], "tokens": ["d.secret3"], "webhooks": ["https://hooks.example.com/webhook1"]}]`,
+	}
+	c, err := Load(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	b, err := json.Marshal(c.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret", "key1", "password1", "bearer1", "webhook1"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("Redacted contains %q: %s", secret, b)
+		}
+	}
+	if !strings.Contains(string(b), `"BUFFER_SIZES":["REDACTED:50000"]`) {
+		t.Errorf("Redacted does not keep the buffer sizes: %s", b)
+	}
+	if !strings.Contains(string(b), `"READ_BASIC_AUTH":["user:REDACTED"]`) {
+		t.Errorf("Redacted does not keep the basic auth user: %s", b)
+	}
+	if !strings.Contains(string(b), `"SYSLOG_UDP_ROUTES":["2001:db8::/32:REDACTED","nginx:REDACTED"]`) {
+		t.Errorf("Redacted does not keep the route sources: %s", b)
+	}
+}
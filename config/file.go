@@ -0,0 +1,335 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pairSettings are the settings made of comma separated "name:value" pairs,
+// written in a file as a table or map of names to values.
+var pairSettings = map[string]bool{
+	"BUFFER_SIZES":    true,
+	"READ_KEYS":       true,
+	"READ_BASIC_AUTH": true,
+
+	"SYSLOG_UDP_ROUTES": true,
+}
+
+// parseFile reads the settings in a TOML or YAML file, by environment
+// variable name. Only the subset of each needed for flat settings is
+// understood: scalars, single line arrays or lists, and tables or maps
+// one level deep. The name of a table prefixes the names of its keys, so
+//
+//	[redis]
+//	pool_size = 8
+//
+// sets REDIS_POOL_SIZE, except for tables of pairs such as buffer_sizes.
+func parseFile(name string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f := fileSettings{values: make(map[string]string), pairs: make(map[string]bool)}
+	switch ext := filepath.Ext(name); ext {
+	case ".toml":
+		err = f.parseTOML(data)
+	case ".yaml", ".yml":
+		err = f.parseYAML(data)
+	default:
+		err = fmt.Errorf("unknown file type %q, expected .toml, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.values, nil
+}
+
+type fileSettings struct {
+	values map[string]string
+	pairs  map[string]bool // settings written as tables, and "SETTING name" of their pairs
+}
+
+// set records key, within section if any, as found on line n.
+func (f fileSettings) set(n int, section, key, value string) error {
+	name := envName(key)
+	if section != "" {
+		prefix := envName(section)
+		if pairSettings[prefix] {
+			if _, scalar := f.values[prefix]; scalar && !f.pairs[prefix] || f.pairs[prefix+" "+key] {
+				return fmt.Errorf("line %d: %s %q is set more than once", n, prefix, key)
+			}
+			f.pairs[prefix], f.pairs[prefix+" "+key] = true, true
+			if f.values[prefix] != "" {
+				f.values[prefix] += ","
+			}
+			f.values[prefix] += key + ":" + value
+			return nil
+		}
+		name = prefix + "_" + name
+	}
+
+	if !known(name) {
+		return fmt.Errorf("line %d: unknown setting %q", n, name)
+	}
+	if _, dup := f.values[name]; dup {
+		return fmt.Errorf("line %d: %s is set more than once", n, name)
+	}
+	f.values[name] = value
+	return nil
+}
+
+func (f fileSettings) parseTOML(data []byte) error {
+	var section string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(s.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: invalid table %q", n, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" || strings.ContainsAny(section, ".[]") {
+				return fmt.Errorf("line %d: only tables one level deep are supported", n)
+			}
+			continue
+		}
+
+		i := indexKey(line, '=')
+		if i <= 0 {
+			return fmt.Errorf("line %d: expected key = value", n)
+		}
+		key, err := scalar(line[:i])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		value, err := parseValue(line[i+1:])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if err := f.set(n, section, key, value); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+func (f fileSettings) parseYAML(data []byte) error {
+	var (
+		parent   string // the key of the map or list being read, if any
+		parentAt int
+		items    []string
+	)
+	endList := func() error {
+		if parent != "" && items != nil {
+			if err := f.set(parentAt, "", parent, strings.Join(items, ",")); err != nil {
+				return err
+			}
+		}
+		items = nil
+		return nil
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		raw := stripComment(s.Text())
+		line := strings.TrimSpace(raw)
+		if line == "" || line == "---" {
+			continue
+		}
+		nested := raw[0] == ' ' || raw[0] == '\t'
+
+		if !nested {
+			if err := endList(); err != nil {
+				return err
+			}
+			parent = ""
+		} else if parent == "" {
+			return fmt.Errorf("line %d: unexpected indentation", n)
+		}
+
+		if nested && (line == "-" || strings.HasPrefix(line, "- ")) {
+			item, err := scalar(strings.TrimPrefix(line, "-"))
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+			items = append(items, item)
+			continue
+		}
+		if items != nil {
+			return fmt.Errorf("line %d: expected a list item", n)
+		}
+
+		i := indexKey(line, ':')
+		if i <= 0 {
+			return fmt.Errorf("line %d: expected key: value", n)
+		}
+		key, err := scalar(line[:i])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		rest := strings.TrimSpace(line[i+1:])
+
+		if nested {
+			value, err := parseValue(rest)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+			if err := f.set(n, parent, key, value); err != nil {
+				return err
+			}
+			continue
+		}
+		if rest == "" {
+			parent, parentAt = key, n
+			continue
+		}
+		value, err := parseValue(rest)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if err := f.set(n, "", key, value); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return endList()
+}
+
+// parseValue reads a scalar or a single line array, joining its items with commas.
+func parseValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		return scalar(s)
+	}
+	if !strings.HasSuffix(s, "]") {
+		return "", fmt.Errorf("arrays must be written on a single line")
+	}
+
+	var items []string
+	for _, item := range splitList(s[1 : len(s)-1]) {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		v, err := scalar(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, v)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// scalar reads a plain, 'single' or "double" quoted scalar.
+func scalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	}
+	return s, nil
+}
+
+// stripComment removes a # comment, which may not start inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// indexKey returns the index of the sep ending the key of line, outside of
+// strings, or -1. As in YAML, a ':' only ends a key when followed by a space
+// or the end of the line, so keys such as IPv6 routes may be left unquoted.
+func indexKey(line string, sep byte) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == sep:
+			if sep != ':' || i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitList splits s on commas outside of strings.
+func splitList(s string) []string {
+	var (
+		items []string
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// envName returns the environment variable name of a file key, eg
+// "pool-size" becomes "POOL_SIZE".
+func envName(key string) string {
+	return strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+func known(name string) bool {
+	for _, s := range settings {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
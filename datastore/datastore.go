@@ -8,13 +8,20 @@ var (
 )
 
 // Datastore is the main interface into the db package.
+//
+// Every implementation keeps lines in the order they were inserted: List
+// returns a token's lines oldest first, as does Query unless asked for
+// NewestFirst. Implementations are checked against this contract by the
+// datastoretest package.
 type Datastore interface {
 	HealthChecker
 	Inserter
 	Lister
+	Closer
 }
 
-// Inserter is the interface for inserting records into the Datastore.
+// Inserter is the interface for inserting records into the Datastore. Callers
+// may reuse lines once Insert returns, so implementations must not retain it.
 type Inserter interface {
 	Insert(token string, lines []string) (int, error)
 }
@@ -24,7 +31,26 @@ type HealthChecker interface {
 	Healthcheck() (bool, error)
 }
 
-// Lister is the interface for listing logs stored in the Datastore.
+// Closer is the interface for releasing the resources of the Datastore, such
+// as background workers and connections, and writing out anything buffered.
+// Subscriptions are ended. Close may be called more than once; nothing else
+// may be called after it.
+type Closer interface {
+	Close() error
+}
+
+// Lister is the interface for listing logs stored in the Datastore, oldest first.
 type Lister interface {
 	List(token string) ([]string, error)
+	Query(token string, q Query) (*Page, error)
+}
+
+// Subscriber is the interface for following logs as they are inserted into the Datastore.
+type Subscriber interface {
+	Subscribe(token string) (*Subscription, error)
+}
+
+// Sizer is the interface for reporting how many lines are buffered per token.
+type Sizer interface {
+	Sizes() (map[string]int, error)
 }
@@ -0,0 +1,370 @@
+// Package datastoretest provides a conformance suite every datastore.Datastore
+// implementation is expected to pass, along with in-process fakes of redis and
+// S3 so the suite runs without external services.
+package datastoretest
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/heroku/log-boom/datastore"
+)
+
+// Factory returns a new, empty Datastore keeping keep lines per token.
+type Factory func(keep int) (ds.Datastore, error)
+
+// Run runs the conformance suite against Datastores created by newDB.
+func Run(t *testing.T, newDB Factory) {
+	t.Run("Healthcheck", func(t *testing.T) { testHealthcheck(t, newDB) })
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, newDB) })
+	t.Run("NoSuchToken", func(t *testing.T) { testNoSuchToken(t, newDB) })
+	t.Run("Eviction", func(t *testing.T) { testEviction(t, newDB) })
+	t.Run("Ordering", func(t *testing.T) { testOrdering(t, newDB) })
+	t.Run("Paging", func(t *testing.T) { testPaging(t, newDB) })
+	t.Run("Filter", func(t *testing.T) { testFilter(t, newDB) })
+	t.Run("Isolation", func(t *testing.T) { testIsolation(t, newDB) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newDB) })
+	t.Run("Subscribe", func(t *testing.T) { testSubscribe(t, newDB) })
+	t.Run("Close", func(t *testing.T) { testClose(t, newDB) })
+}
+
+func testHealthcheck(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	ok, err := db.Healthcheck()
+	if !ok || err != nil {
+		t.Errorf("Healthcheck = %v, %v, want true, nil", ok, err)
+	}
+}
+
+func testRoundTrip(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	mustInsert(t, db, "token", lines(1, 3))
+
+	got, err := db.List("token")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if want := lines(1, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %q, want %q", got, want)
+	}
+}
+
+func testNoSuchToken(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	if _, err := db.List("missing"); err != ds.ErrNoSuchToken {
+		t.Errorf("List = %v, want %v", err, ds.ErrNoSuchToken)
+	}
+	if _, err := db.Query("missing", ds.Query{}); err != ds.ErrNoSuchToken {
+		t.Errorf("Query = %v, want %v", err, ds.ErrNoSuchToken)
+	}
+}
+
+func testEviction(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 5)
+
+	mustInsert(t, db, "token", lines(1, 3))
+	mustInsert(t, db, "token", lines(4, 8))
+
+	got, err := db.List("token")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if want := lines(4, 8); !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %q, want the newest 5 %q", got, want)
+	}
+}
+
+func testOrdering(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	mustInsert(t, db, "token", lines(1, 3))
+	mustInsert(t, db, "token", lines(4, 5))
+
+	got, err := db.List("token")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if want := lines(1, 5); !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %q, want oldest first %q", got, want)
+	}
+
+	page, err := db.Query("token", ds.Query{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := lines(1, 5); !reflect.DeepEqual(page.Lines, want) {
+		t.Errorf("Query(OldestFirst) = %q, want %q", page.Lines, want)
+	}
+
+	page, err = db.Query("token", ds.Query{Order: ds.NewestFirst})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := reversed(lines(1, 5)); !reflect.DeepEqual(page.Lines, want) {
+		t.Errorf("Query(NewestFirst) = %q, want %q", page.Lines, want)
+	}
+}
+
+func testPaging(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 5)
+
+	mustInsert(t, db, "token", lines(1, 8))
+
+	tests := []struct {
+		q    ds.Query
+		want []string
+		next int64
+	}{
+		{ds.Query{}, lines(4, 8), 0},
+		{ds.Query{Limit: 2}, lines(4, 5), 6},
+		{ds.Query{Limit: 2, Cursor: 6}, lines(6, 7), 8},
+		{ds.Query{Limit: 2, Cursor: 8}, lines(8, 8), 0},
+		{ds.Query{Cursor: 1}, lines(4, 8), 0},
+		{ds.Query{Order: ds.NewestFirst, Limit: 2}, reversed(lines(7, 8)), 6},
+		{ds.Query{Order: ds.NewestFirst, Limit: 2, Cursor: 5}, reversed(lines(4, 5)), 0},
+		{ds.Query{Cursor: 9}, nil, 0},
+	}
+	for _, tt := range tests {
+		page, err := db.Query("token", tt.q)
+		if err != nil {
+			t.Fatalf("Query(%+v): %v", tt.q, err)
+		}
+		if !equal(page.Lines, tt.want) || page.Next != tt.next {
+			t.Errorf("Query(%+v) = %q, %d, want %q, %d", tt.q, page.Lines, page.Next, tt.want, tt.next)
+		}
+		if page.Last != 8 {
+			t.Errorf("Query(%+v) Last = %d, want 8", tt.q, page.Last)
+		}
+	}
+
+	// Cursors keep their place as new lines are inserted.
+	page, err := db.Query("token", ds.Query{Limit: 2})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	mustInsert(t, db, "token", lines(9, 9))
+	page, err = db.Query("token", ds.Query{Limit: 2, Cursor: page.Next})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := lines(6, 7); !reflect.DeepEqual(page.Lines, want) {
+		t.Errorf("Query after Insert = %q, want %q", page.Lines, want)
+	}
+}
+
+func testFilter(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	var all []string
+	for i := 1; i <= 8; i++ {
+		proc, severity := "web.1", 6
+		if i%2 == 0 {
+			proc, severity = "router", 3
+		}
+		all = append(all, fmt.Sprintf("<%d>1 2016-01-01T00:00:0%dZ host app %s - line %d", 8+severity, i, proc, i))
+	}
+	mustInsert(t, db, "token", all)
+
+	router := ds.Filter{ProcID: "router"}
+	tests := []struct {
+		q    ds.Query
+		want []string
+		next int64
+	}{
+		{ds.Query{Filter: router}, []string{all[1], all[3], all[5], all[7]}, 0},
+		{ds.Query{Filter: router, Limit: 2}, []string{all[1], all[3]}, 5},
+		{ds.Query{Filter: router, Limit: 2, Cursor: 5}, []string{all[5], all[7]}, 0},
+		{ds.Query{Filter: router, Limit: 1, Order: ds.NewestFirst}, []string{all[7]}, 7},
+		{ds.Query{Filter: ds.Filter{Severities: ds.SeverityRange(0, 4)}}, []string{all[1], all[3], all[5], all[7]}, 0},
+		{ds.Query{Filter: ds.Filter{Contains: "line 3"}}, []string{all[2]}, 0},
+		{ds.Query{Filter: ds.Filter{AppName: "other"}}, nil, 0},
+	}
+	for _, tt := range tests {
+		page, err := db.Query("token", tt.q)
+		if err != nil {
+			t.Fatalf("Query(%+v): %v", tt.q, err)
+		}
+		if !equal(page.Lines, tt.want) || page.Next != tt.next {
+			t.Errorf("Query(%+v) = %q, %d, want %q, %d", tt.q, page.Lines, page.Next, tt.want, tt.next)
+		}
+	}
+}
+
+func testIsolation(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	mustInsert(t, db, "a", lines(1, 2))
+	mustInsert(t, db, "b", lines(3, 4))
+
+	for token, want := range map[string][]string{"a": lines(1, 2), "b": lines(3, 4)} {
+		got, err := db.List(token)
+		if err != nil {
+			t.Fatalf("List(%q): %v", token, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("List(%q) = %q, want %q", token, got, want)
+		}
+	}
+}
+
+func testConcurrency(t *testing.T, newDB Factory) {
+	const (
+		keep    = 50
+		workers = 8
+		inserts = 20
+	)
+	db := mustNew(t, newDB, keep)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			token := fmt.Sprintf("token-%d", w%2)
+			for i := 0; i < inserts; i++ {
+				if _, err := db.Insert(token, lines(1, 3)); err != nil {
+					t.Errorf("Insert: %v", err)
+					return
+				}
+				if _, err := db.List(token); err != nil {
+					t.Errorf("List: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, token := range []string{"token-0", "token-1"} {
+		got, err := db.List(token)
+		if err != nil {
+			t.Fatalf("List(%q): %v", token, err)
+		}
+		if len(got) != keep {
+			t.Errorf("List(%q) returned %d lines, want %d", token, len(got), keep)
+		}
+	}
+}
+
+func testSubscribe(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+	defer db.Close()
+
+	s, ok := db.(ds.Subscriber)
+	if !ok {
+		t.Skip("not a Subscriber")
+	}
+	sub, err := s.Subscribe("token")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	// Lines inserted once Subscribe returns must all be received.
+	mustInsert(t, db, "other", lines(1, 1))
+	mustInsert(t, db, "token", lines(1, 1))
+	mustInsert(t, db, "token", lines(2, 4))
+
+	var (
+		got      []ds.Line
+		deadline = time.After(5 * time.Second)
+	)
+	for len(got) < 4 {
+		select {
+		case l := <-sub.C:
+			got = append(got, l)
+		case <-deadline:
+			t.Fatalf("received %v, want 4 lines", got)
+		}
+	}
+	// Lines carry their sequence numbers, which match their text here.
+	if got[0].Seq != 1 {
+		t.Errorf("received %v first, want line 1", got[0])
+	}
+	for i, l := range got {
+		if i > 0 && l.Seq != got[i-1].Seq+1 {
+			t.Errorf("received %v, want consecutive lines", got)
+			break
+		}
+		if l.Text != fmt.Sprintf("line %d", l.Seq) {
+			t.Errorf("received %q numbered %d", l.Text, l.Seq)
+		}
+	}
+}
+
+func testClose(t *testing.T, newDB Factory) {
+	db := mustNew(t, newDB, 10)
+
+	var sub *ds.Subscription
+	if s, ok := db.(ds.Subscriber); ok {
+		var err error
+		if sub, err = s.Subscribe("token"); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	}
+	mustInsert(t, db, "token", lines(1, 3))
+
+	for i := 0; i < 2; i++ {
+		if err := db.Close(); err != nil {
+			t.Errorf("Close #%d: %v", i+1, err)
+		}
+	}
+
+	if sub != nil {
+		for range sub.C {
+		}
+		if err := sub.Err(); err != nil {
+			t.Errorf("Subscription.Err after Close = %v, want nil", err)
+		}
+	}
+}
+
+func mustNew(t *testing.T, newDB Factory, keep int) ds.Datastore {
+	db, err := newDB(keep)
+	if err != nil {
+		t.Fatalf("creating datastore: %v", err)
+	}
+	return db
+}
+
+func mustInsert(t *testing.T, db ds.Datastore, token string, lines []string) {
+	n, err := db.Insert(token, lines)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if n != len(lines) {
+		t.Fatalf("Insert = %d, want %d", n, len(lines))
+	}
+}
+
+// lines returns the lines numbered from through to.
+func lines(from, to int) []string {
+	l := make([]string, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		l = append(l, fmt.Sprintf("line %d", i))
+	}
+	return l
+}
+
+// equal is reflect.DeepEqual treating nil and empty slices alike.
+func equal(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func reversed(l []string) []string {
+	r := make([]string, len(l))
+	for i, line := range l {
+		r[len(l)-1-i] = line
+	}
+	return r
+}
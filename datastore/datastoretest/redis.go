@@ -0,0 +1,511 @@
+package datastoretest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// RedisServer is an in-process fake of the subset of redis used by
+// datastore.RedisDB, so it can be tested without an external redis.
+type RedisServer struct {
+	l net.Listener
+
+	mu        sync.Mutex
+	strings   map[string]string
+	lists     map[string][]string // the head of the list is index 0
+	zsets     map[string]map[string]float64
+	expires   map[string]time.Time
+	subs      map[*redisConn]map[string]bool // the channels each connection is subscribed to
+	publishes int
+}
+
+type redisConn struct {
+	net.Conn
+	wmu sync.Mutex
+}
+
+var errWrongArgs = errors.New("ERR wrong number of arguments")
+
+// NewRedisServer starts a RedisServer listening on a random local port.
+func NewRedisServer() (*RedisServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RedisServer{
+		l:       l,
+		strings: make(map[string]string),
+		lists:   make(map[string][]string),
+		zsets:   make(map[string]map[string]float64),
+		expires: make(map[string]time.Time),
+		subs:    make(map[*redisConn]map[string]bool),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// URL returns the redis:// URL of the server.
+func (s *RedisServer) URL() *url.URL {
+	return &url.URL{Scheme: "redis", Host: s.l.Addr().String()}
+}
+
+// Subscribers returns the number of connections subscribed to channel.
+func (s *RedisServer) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numsub(channel)
+}
+
+// Publishes returns the number of PUBLISH commands run.
+func (s *RedisServer) Publishes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.publishes
+}
+
+// Close stops the server from accepting new connections.
+func (s *RedisServer) Close() error {
+	return s.l.Close()
+}
+
+func (s *RedisServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(&redisConn{Conn: conn})
+	}
+}
+
+func (s *RedisServer) handle(c *redisConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	var (
+		rr    = redis.NewRespReader(c)
+		multi [][]string
+		inTx  bool
+	)
+	for {
+		r := rr.Read()
+		if r.IsType(redis.IOErr) {
+			return
+		}
+		args, err := r.List()
+		if err != nil || len(args) == 0 {
+			c.write(errors.New("ERR protocol error"))
+			continue
+		}
+		args[0] = strings.ToUpper(args[0])
+
+		switch {
+		case args[0] == "MULTI":
+			inTx, multi = true, nil
+			c.write(redis.NewRespSimple("OK"))
+		case args[0] == "EXEC":
+			s.mu.Lock()
+			replies := make([]interface{}, 0, len(multi))
+			for _, cmd := range multi {
+				replies = append(replies, s.do(c, cmd))
+			}
+			s.mu.Unlock()
+			inTx, multi = false, nil
+			c.write(replies)
+		case inTx:
+			multi = append(multi, args)
+			c.write(redis.NewRespSimple("QUEUED"))
+		default:
+			s.mu.Lock()
+			reply := s.do(c, args)
+			s.mu.Unlock()
+			if rs, ok := reply.(replies); ok {
+				for _, r := range rs {
+					c.write(r)
+				}
+				continue
+			}
+			c.write(reply)
+		}
+	}
+}
+
+// do executes a single command. The caller must hold s.mu.
+func (s *RedisServer) do(c *redisConn, args []string) interface{} {
+	cmd, args := args[0], args[1:]
+	s.purge()
+
+	switch cmd {
+	case "PING":
+		return redis.NewRespSimple("PONG")
+	case "AUTH":
+		return redis.NewRespSimple("OK")
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if s.exists(key) {
+				n++
+			}
+		}
+		return n
+	case "DEL":
+		n := 0
+		for _, key := range args {
+			if s.exists(key) {
+				n++
+			}
+			s.delete(key)
+		}
+		return n
+	case "GET":
+		if len(args) != 1 {
+			return errWrongArgs
+		}
+		if v, ok := s.strings[args[0]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		if len(args) < 2 {
+			return errWrongArgs
+		}
+		s.delete(args[0])
+		s.strings[args[0]] = args[1]
+		return redis.NewRespSimple("OK")
+	case "INCRBY":
+		if len(args) != 2 {
+			return errWrongArgs
+		}
+		by, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer")
+		}
+		n, _ := strconv.ParseInt(s.strings[args[0]], 10, 64)
+		n += by
+		s.strings[args[0]] = strconv.FormatInt(n, 10)
+		return n
+	case "LPUSH":
+		if len(args) < 2 {
+			return errWrongArgs
+		}
+		list := s.lists[args[0]]
+		for _, v := range args[1:] {
+			list = append([]string{v}, list...)
+		}
+		s.lists[args[0]] = list
+		return len(list)
+	case "LLEN":
+		if len(args) != 1 {
+			return errWrongArgs
+		}
+		return len(s.lists[args[0]])
+	case "LRANGE":
+		if len(args) != 3 {
+			return errWrongArgs
+		}
+		list := s.lists[args[0]]
+		lo, hi, ok := listRange(len(list), args[1], args[2])
+		if !ok {
+			return []string{}
+		}
+		return append([]string{}, list[lo:hi+1]...)
+	case "LTRIM":
+		if len(args) != 3 {
+			return errWrongArgs
+		}
+		list := s.lists[args[0]]
+		lo, hi, ok := listRange(len(list), args[1], args[2])
+		if !ok {
+			s.delete(args[0])
+		} else {
+			s.lists[args[0]] = append([]string{}, list[lo:hi+1]...)
+		}
+		return redis.NewRespSimple("OK")
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return errWrongArgs
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer")
+		}
+		if !s.exists(args[0]) {
+			return 0
+		}
+		s.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return 1
+	case "ZADD":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errWrongArgs
+		}
+		set, ok := s.zsets[args[0]]
+		if !ok {
+			set = make(map[string]float64)
+		}
+		n := 0
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return errors.New("ERR value is not a valid float")
+			}
+			if _, ok := set[args[i+1]]; !ok {
+				n++
+			}
+			set[args[i+1]] = score
+		}
+		s.zsets[args[0]] = set
+		return n
+	case "ZREVRANGEBYSCORE":
+		if len(args) != 3 && len(args) != 6 {
+			return errWrongArgs
+		}
+		max, err1 := parseScore(args[1])
+		min, err2 := parseScore(args[2])
+		if err1 != nil || err2 != nil {
+			return errors.New("ERR min or max is not a float")
+		}
+		offset, count := 0, -1
+		if len(args) == 6 {
+			offset, _ = strconv.Atoi(args[4])
+			count, _ = strconv.Atoi(args[5])
+		}
+		members := []string{}
+		sorted := s.sorted(args[0])
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if score := s.zsets[args[0]][sorted[i]]; score < min || score > max {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if count >= 0 && len(members) == count {
+				break
+			}
+			members = append(members, sorted[i])
+		}
+		return members
+	case "ZREMRANGEBYSCORE":
+		if len(args) != 3 {
+			return errWrongArgs
+		}
+		min, err1 := parseScore(args[1])
+		max, err2 := parseScore(args[2])
+		if err1 != nil || err2 != nil {
+			return errors.New("ERR min or max is not a float")
+		}
+		n := 0
+		for member, score := range s.zsets[args[0]] {
+			if score >= min && score <= max {
+				delete(s.zsets[args[0]], member)
+				n++
+			}
+		}
+		s.dropEmpty(args[0])
+		return n
+	case "ZREMRANGEBYRANK":
+		if len(args) != 3 {
+			return errWrongArgs
+		}
+		sorted := s.sorted(args[0])
+		lo, hi, ok := listRange(len(sorted), args[1], args[2])
+		if !ok {
+			return 0
+		}
+		for _, member := range sorted[lo : hi+1] {
+			delete(s.zsets[args[0]], member)
+		}
+		s.dropEmpty(args[0])
+		return hi - lo + 1
+	case "PUBLISH":
+		if len(args) != 2 {
+			return errWrongArgs
+		}
+		s.publishes++
+		n := 0
+		for sub, channels := range s.subs {
+			if channels[args[0]] {
+				sub.write([]string{"message", args[0], args[1]})
+				n++
+			}
+		}
+		return n
+	case "PUBSUB":
+		if len(args) == 0 || strings.ToUpper(args[0]) != "NUMSUB" {
+			return errors.New("ERR unknown PUBSUB subcommand")
+		}
+		reply := []interface{}{}
+		for _, channel := range args[1:] {
+			reply = append(reply, channel, s.numsub(channel))
+		}
+		return reply
+	case "SUBSCRIBE":
+		if len(args) == 0 {
+			return errWrongArgs
+		}
+		channels, ok := s.subs[c]
+		if !ok {
+			channels = make(map[string]bool)
+			s.subs[c] = channels
+		}
+		var rs replies
+		for _, channel := range args {
+			channels[channel] = true
+			rs = append(rs, []interface{}{"subscribe", channel, len(channels)})
+		}
+		return rs
+	case "UNSUBSCRIBE":
+		channels := s.subs[c]
+		if len(args) == 0 {
+			for channel := range channels {
+				args = append(args, channel)
+			}
+		}
+		var rs replies
+		for _, channel := range args {
+			delete(channels, channel)
+			rs = append(rs, []interface{}{"unsubscribe", channel, len(channels)})
+		}
+		return rs
+	default:
+		return fmt.Errorf("ERR unknown command '%s'", cmd)
+	}
+}
+
+// replies are written one after another, as redis does for each channel of
+// SUBSCRIBE and UNSUBSCRIBE.
+type replies []interface{}
+
+// numsub counts the connections subscribed to channel. The caller must hold
+// s.mu.
+func (s *RedisServer) numsub(channel string) int {
+	n := 0
+	for _, channels := range s.subs {
+		if channels[channel] {
+			n++
+		}
+	}
+	return n
+}
+
+// purge deletes expired keys. The caller must hold s.mu.
+func (s *RedisServer) purge() {
+	now := time.Now()
+	for key, at := range s.expires {
+		if !now.Before(at) {
+			s.delete(key)
+		}
+	}
+}
+
+func (s *RedisServer) delete(key string) {
+	delete(s.strings, key)
+	delete(s.lists, key)
+	delete(s.zsets, key)
+	delete(s.expires, key)
+}
+
+func (s *RedisServer) exists(key string) bool {
+	_, str := s.strings[key]
+	_, list := s.lists[key]
+	_, zset := s.zsets[key]
+	return str || list || zset
+}
+
+// sorted returns the members of a sorted set by ascending score, and then
+// member.
+func (s *RedisServer) sorted(key string) []string {
+	set := s.zsets[key]
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if set[a] != set[b] {
+			return set[a] < set[b]
+		}
+		return a < b
+	})
+	return members
+}
+
+// dropEmpty deletes a sorted set left without members, as redis does.
+func (s *RedisServer) dropEmpty(key string) {
+	if set, ok := s.zsets[key]; ok && len(set) == 0 {
+		s.delete(key)
+	}
+}
+
+// parseScore parses a sorted set score bound, which may be -inf or +inf.
+func parseScore(v string) (float64, error) {
+	switch v {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// listRange resolves redis start and stop indexes, which may be negative,
+// against a list of length n.
+func listRange(n int, start, stop string) (int, int, bool) {
+	lo, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, false
+	}
+	hi, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if lo < 0 {
+		lo += n
+	}
+	if hi < 0 {
+		hi += n
+	}
+	if lo < 0 {
+		lo = 0
+	}
+	if hi >= n {
+		hi = n - 1
+	}
+	if lo > hi {
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+func (c *redisConn) write(v interface{}) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var r *redis.Resp
+	switch v := v.(type) {
+	case *redis.Resp:
+		r = v
+	default:
+		r = redis.NewResp(v)
+	}
+	if _, err := r.WriteTo(c); err != nil && err != io.EOF {
+		c.Close()
+	}
+}
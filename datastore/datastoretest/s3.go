@@ -0,0 +1,124 @@
+package datastoretest
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// S3Server is an in-process fake of the subset of the S3 API used by
+// datastore.S3DB. Buckets are addressed path-style and created on first use.
+// Requests must be signed, but signatures are not verified.
+type S3Server struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]map[string][]byte
+	failing bool
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Contents    []s3Object
+	IsTruncated bool
+}
+
+type s3Object struct {
+	Key string
+}
+
+// NewS3Server starts an S3Server listening on a random local port.
+func NewS3Server() *S3Server {
+	s := &S3Server{objects: make(map[string]map[string][]byte)}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL returns the endpoint of the server.
+func (s *S3Server) URL() *url.URL {
+	u, _ := url.Parse(s.srv.URL)
+	return u
+}
+
+// FailWrites makes every following PUT fail with a 503 if fail is true, or
+// succeed again if it is false.
+func (s *S3Server) FailWrites(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = fail
+}
+
+// Close shuts the server down.
+func (s *S3Server) Close() {
+	s.srv.Close()
+}
+
+func (s *S3Server) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", 403)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.objects[bucket]
+	if !ok {
+		objects = make(map[string][]byte)
+		s.objects[bucket] = objects
+	}
+
+	switch {
+	case r.Method == "HEAD" && key == "":
+		w.WriteHeader(200)
+	case r.Method == "GET" && key == "":
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for k := range objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		result := listBucketResult{}
+		for _, k := range keys {
+			result.Contents = append(result.Contents, s3Object{Key: k})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == "PUT" && s.failing:
+		http.Error(w, "<Error><Code>ServiceUnavailable</Code></Error>", 503)
+	case r.Method == "PUT":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		objects[key] = body
+		w.WriteHeader(200)
+	case r.Method == "GET":
+		body, ok := objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", 404)
+			return
+		}
+		w.Write(body)
+	case r.Method == "DELETE":
+		delete(objects, key)
+		w.WriteHeader(204)
+	default:
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", 501)
+	}
+}
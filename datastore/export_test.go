@@ -0,0 +1,23 @@
+package datastore
+
+import "time"
+
+// Trim runs a sweep of token, as if lines had been inserted since the last.
+func (db *RedisDB) Trim(token string) error {
+	return db.trim(token, true)
+}
+
+// SetClock makes db read the time from now.
+func (db *RedisDB) SetClock(now func() time.Time) {
+	db.now = now
+}
+
+// SetClock makes db read the time from now.
+func (db *MemoryDB) SetClock(now func() time.Time) {
+	db.now = now
+}
+
+// Sweep runs a sweep of every token's lines.
+func (db *MemoryDB) Sweep() {
+	db.sweepOnce()
+}
@@ -0,0 +1,97 @@
+package datastore
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/heroku/log-boom/syslog"
+)
+
+// Filter selects lines by their parsed syslog fields. The zero Filter matches
+// every line; lines which can't be parsed only match the zero Filter.
+type Filter struct {
+	// AppName and ProcID are path.Match patterns, eg "app" or "web.*".
+	AppName string
+	ProcID  string
+
+	// Severities is a bitmask of the severities to match, the bit for
+	// syslog.Emergency being 1 and for syslog.Debug 1<<7. 0 matches every
+	// severity.
+	Severities uint8
+
+	// Since and Until bound the timestamp of matching lines, Since inclusive
+	// and Until exclusive. The zero time leaves that end open.
+	Since time.Time
+	Until time.Time
+
+	// Contains and Regexp match the message body.
+	Contains string
+	Regexp   *regexp.Regexp
+}
+
+// SeverityRange returns the Severities bitmask matching lo through hi
+// inclusive, in either order.
+func SeverityRange(lo, hi int) uint8 {
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+
+	var mask uint8
+	for s := lo; s <= hi; s++ {
+		if s >= syslog.Emergency && s <= syslog.Debug {
+			mask |= 1 << uint(s)
+		}
+	}
+	return mask
+}
+
+// IsZero reports whether f matches every line.
+func (f Filter) IsZero() bool {
+	return f.AppName == "" && f.ProcID == "" && f.Severities == 0 &&
+		f.Since.IsZero() && f.Until.IsZero() &&
+		f.Contains == "" && f.Regexp == nil
+}
+
+// Match reports whether line is selected by f.
+func (f Filter) Match(line string) bool {
+	if f.IsZero() {
+		return true
+	}
+
+	m, err := syslog.Parse([]byte(line))
+	if err != nil {
+		return false
+	}
+	return f.MatchMessage(m)
+}
+
+// MatchMessage reports whether the parsed message m is selected by f.
+func (f Filter) MatchMessage(m *syslog.Message) bool {
+	switch {
+	case !matchPattern(f.AppName, m.AppName):
+		return false
+	case !matchPattern(f.ProcID, m.ProcID):
+		return false
+	case f.Severities != 0 && f.Severities&(1<<uint(m.Severity)) == 0:
+		return false
+	case !f.Since.IsZero() && m.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !m.Timestamp.Before(f.Until):
+		return false
+	case f.Contains != "" && !strings.Contains(m.Message, f.Contains):
+		return false
+	case f.Regexp != nil && !f.Regexp.MatchString(m.Message):
+		return false
+	}
+	return true
+}
+
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
@@ -0,0 +1,149 @@
+package datastore
+
+import (
+	"errors"
+	"sync"
+)
+
+// SubscriberBuffer is the number of lines a Subscription may fall behind
+// before it is dropped as a slow consumer.
+const SubscriberBuffer = 1024
+
+// Errors returned by Subscription.
+var (
+	ErrSlowConsumer = errors.New("slow consumer")
+)
+
+// Hub fans inserted lines out to every Subscription for a token.
+type Hub struct {
+	size int
+
+	// idle, if set, is called with mu held when a token loses its last
+	// Subscription.
+	idle func(token string)
+
+	mu   sync.Mutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+// Line is a published line along with its sequence number, as numbered for
+// Query, or 0 if it isn't known.
+type Line struct {
+	Seq  int64
+	Text string
+}
+
+// Subscription receives lines published for a single token.
+type Subscription struct {
+	// C receives published lines. It is closed when the subscription ends.
+	C <-chan Line
+
+	c     chan Line
+	hub   *Hub
+	token string
+	err   error
+}
+
+// NewHub creates a Hub whose subscriptions buffer up to size lines.
+func NewHub(size int) *Hub {
+	return &Hub{
+		size: size,
+		subs: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new Subscription for token.
+func (h *Hub) Subscribe(token string) *Subscription {
+	c := make(chan Line, h.size)
+	s := &Subscription{C: c, c: c, hub: h, token: token}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	set, ok := h.subs[token]
+	if !ok {
+		set = make(map[*Subscription]struct{})
+		h.subs[token] = set
+	}
+	set[s] = struct{}{}
+	return s
+}
+
+// Publish sends lines to every Subscription for token without blocking. last
+// is the sequence number of the final line, or 0 if it isn't known. A
+// Subscription which cannot keep up is closed with ErrSlowConsumer.
+func (h *Hub) Publish(token string, last int64, lines []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs[token] {
+		for i, line := range lines {
+			l := Line{Text: line}
+			if last > 0 {
+				l.Seq = last - int64(len(lines)-1-i)
+			}
+			if !s.send(l) {
+				h.remove(s, ErrSlowConsumer)
+				break
+			}
+		}
+	}
+}
+
+// send queues line without blocking, reporting whether there was room.
+func (s *Subscription) send(line Line) bool {
+	select {
+	case s.c <- line:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close ends every subscription.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, set := range h.subs {
+		for s := range set {
+			h.remove(s, nil)
+		}
+	}
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s, nil)
+}
+
+// Err returns ErrSlowConsumer once C is closed if the subscription was
+// dropped for falling behind.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// remove unregisters s and closes its channel. The caller must hold h.mu.
+func (h *Hub) remove(s *Subscription, err error) {
+	set, ok := h.subs[s.token]
+	if !ok {
+		return
+	}
+	if _, ok := set[s]; !ok {
+		return
+	}
+
+	delete(set, s)
+	if len(set) == 0 {
+		delete(h.subs, s.token)
+		if h.idle != nil {
+			h.idle(s.token)
+		}
+	}
+	s.err = err
+	close(s.c)
+}
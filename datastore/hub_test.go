@@ -0,0 +1,86 @@
+package datastore_test
+
+import (
+	"reflect"
+	"testing"
+
+	ds "github.com/heroku/log-boom/datastore"
+)
+
+// received returns the lines queued for s, without waiting for more.
+func received(s *ds.Subscription) (lines []ds.Line, closed bool) {
+	for {
+		select {
+		case l, ok := <-s.C:
+			if !ok {
+				return lines, true
+			}
+			lines = append(lines, l)
+		default:
+			return lines, false
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := ds.NewHub(10)
+	a1, a2, b := h.Subscribe("a"), h.Subscribe("a"), h.Subscribe("b")
+
+	h.Publish("a", 12, []string{"x", "y"})
+	h.Publish("a", 0, []string{"z"})
+	h.Publish("c", 1, []string{"nobody"})
+
+	want := []ds.Line{{Seq: 11, Text: "x"}, {Seq: 12, Text: "y"}, {Text: "z"}}
+	for _, s := range []*ds.Subscription{a1, a2} {
+		if got, closed := received(s); closed || !reflect.DeepEqual(got, want) {
+			t.Errorf("received %v, closed %v, want %v", got, closed, want)
+		}
+	}
+	if got, closed := received(b); closed || len(got) != 0 {
+		t.Errorf("b received %v, closed %v", got, closed)
+	}
+
+	a1.Close()
+	a1.Close()
+	h.Publish("a", 13, []string{"w"})
+	if _, closed := received(a1); !closed || a1.Err() != nil {
+		t.Errorf("closed subscription: closed %v, Err %v", closed, a1.Err())
+	}
+	if got, _ := received(a2); len(got) != 1 || got[0].Text != "w" {
+		t.Errorf("remaining subscription received %v", got)
+	}
+
+	h.Close()
+	for _, s := range []*ds.Subscription{a2, b} {
+		if _, closed := received(s); !closed || s.Err() != nil {
+			t.Errorf("after Close: closed %v, Err %v, want closed without error", closed, s.Err())
+		}
+	}
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	h := ds.NewHub(2)
+	slow, fast := h.Subscribe("a"), h.Subscribe("a")
+
+	h.Publish("a", 2, []string{"1", "2"})
+	if got, _ := received(fast); len(got) != 2 {
+		t.Fatalf("received %v, want 2 lines", got)
+	}
+	h.Publish("a", 3, []string{"3"})
+
+	// The slow subscription keeps the lines queued before it fell behind.
+	got, closed := received(slow)
+	if want := []ds.Line{{Seq: 1, Text: "1"}, {Seq: 2, Text: "2"}}; !closed || !reflect.DeepEqual(got, want) {
+		t.Errorf("slow subscription received %v, closed %v, want %v and closed", got, closed, want)
+	}
+	if slow.Err() != ds.ErrSlowConsumer {
+		t.Errorf("Err = %v, want %v", slow.Err(), ds.ErrSlowConsumer)
+	}
+
+	if got, closed := received(fast); closed || len(got) != 1 || got[0].Seq != 3 {
+		t.Errorf("fast subscription received %v, closed %v, want line 3", got, closed)
+	}
+	if fast.Err() != nil {
+		t.Errorf("fast subscription Err = %v", fast.Err())
+	}
+}
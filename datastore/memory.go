@@ -1,18 +1,72 @@
 package datastore
 
-import "container/ring"
+import (
+	"container/ring"
+	"sync"
+	"time"
+)
 
-// MemoryDB implements an in memory Datastore.
+// MemoryDB implements an in memory Datastore. It is safe for concurrent use;
+// each token's buffer is guarded by its own lock so that a busy drain does not
+// serialize access to every other token.
 type MemoryDB struct {
-	keep  int
-	rings map[string]*ring.Ring
+	policy Policy
+	hub    *Hub
+	now    func() time.Time
+	done   chan struct{}
+	closed sync.Once
+
+	mu      sync.RWMutex
+	buffers map[string]*buffer
+	seqs    map[string]int64 // the last sequence number of removed buffers
+}
+
+// buffer is a single token's ring buffer of keep slots. seq is the number of
+// lines ever written for the token and head the slot line 1 was, or would
+// have been, written to, so the line numbered s lives at
+// head.Move((s-1) % keep). The n lines still
+// buffered start at oldest, and r is the slot the next line is written to.
+type buffer struct {
+	mu        sync.Mutex
+	retention Retention
+	keep      int
+	r         *ring.Ring
+	head      *ring.Ring
+	oldest    *ring.Ring
+	seq       int64
+	n         int
+	bytes     int
+
+	// removed is set once the sweeper has dropped the buffer of an idle
+	// token, so inserts must find or create its replacement.
+	removed bool
+}
+
+// entry is a buffered line along with when it was inserted.
+type entry struct {
+	line string
+	at   time.Time
 }
 
-// NewInMemory creates a new in memory Datastore.
+// NewInMemory creates a new in memory Datastore keeping keep lines per token.
 func NewInMemory(keep int) (*MemoryDB, error) {
+	return NewInMemoryWithPolicy(Policy{Default: Retention{MaxLines: keep}})
+}
+
+// NewInMemoryWithPolicy creates a new in memory Datastore enforcing the
+// Retention of each token given by p. If any token has a MaxAge, lines are
+// expired by a background sweeper.
+func NewInMemoryWithPolicy(p Policy) (*MemoryDB, error) {
 	db := &MemoryDB{
-		keep:  keep,
-		rings: make(map[string]*ring.Ring),
+		policy:  p,
+		hub:     NewHub(SubscriberBuffer),
+		now:     time.Now,
+		done:    make(chan struct{}),
+		buffers: make(map[string]*buffer),
+		seqs:    make(map[string]int64),
+	}
+	if interval, ok := p.sweeps(); ok {
+		go db.sweep(interval)
 	}
 	return db, nil
 }
@@ -24,31 +78,250 @@ func (db *MemoryDB) Healthcheck() (bool, error) {
 
 // Insert inserts logs into in memory ring buffer.
 func (db *MemoryDB) Insert(token string, lines []string) (int, error) {
-	buf, ok := db.rings[token]
-	if !ok {
-		buf = ring.New(db.keep)
-		db.rings[token] = buf
-	}
+	now := db.now()
+
+	for {
+		buf := db.buffer(token)
 
-	for _, line := range lines {
-		buf.Value = line
-		buf = buf.Next()
+		buf.mu.Lock()
+		if buf.removed {
+			buf.mu.Unlock()
+			continue
+		}
+		for _, line := range lines {
+			buf.push(entry{line: line, at: now})
+		}
+		buf.seq += int64(len(lines))
+		if max := buf.retention.MaxBytes; max > 0 {
+			for buf.bytes > max && buf.n > 0 {
+				buf.evict()
+			}
+		}
+		// Published under the lock so subscribers see lines in order.
+		db.hub.Publish(token, buf.seq, lines)
+		buf.mu.Unlock()
+		break
 	}
 	return len(lines), nil
 }
 
+// push writes e to the next slot, overwriting the oldest line if the buffer
+// is full. The caller must hold b.mu.
+func (b *buffer) push(e entry) {
+	if b.n == b.keep {
+		b.bytes -= len(b.oldest.Value.(entry).line)
+		b.oldest = b.oldest.Next()
+	} else {
+		b.n++
+	}
+	b.r.Value = e
+	b.r = b.r.Next()
+	b.bytes += len(e.line)
+}
+
+// evict drops the oldest line. The caller must hold b.mu.
+func (b *buffer) evict() {
+	b.bytes -= len(b.oldest.Value.(entry).line)
+	b.oldest.Value = nil
+	b.oldest = b.oldest.Next()
+	b.n--
+}
+
+// expire evicts lines inserted before cutoff. The caller must hold b.mu.
+func (b *buffer) expire(cutoff time.Time) {
+	for b.n > 0 && b.oldest.Value.(entry).at.Before(cutoff) {
+		b.evict()
+	}
+}
+
+// sweep runs sweepOnce every interval until the db is closed.
+func (db *MemoryDB) sweep(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			db.sweepOnce()
+		case <-db.done:
+			return
+		}
+	}
+}
+
+// sweepOnce expires lines older than their MaxAge, and removes the buffers of
+// tokens left without lines. Only one buffer is locked at a time, and the db
+// only to remove one, so inserts and reads carry on during a sweep.
+func (db *MemoryDB) sweepOnce() {
+	db.mu.RLock()
+	buffers := make(map[string]*buffer, len(db.buffers))
+	for token, buf := range db.buffers {
+		buffers[token] = buf
+	}
+	db.mu.RUnlock()
+
+	for token, buf := range buffers {
+		if buf.retention.MaxAge <= 0 {
+			continue
+		}
+		buf.mu.Lock()
+		buf.expireAge(db.now())
+		idle := buf.n == 0
+		buf.mu.Unlock()
+
+		if idle {
+			db.remove(token, buf)
+		}
+	}
+}
+
+// remove drops the buffer of a token without lines, unless lines have been
+// inserted since it was found empty. Its sequence number is kept, so that
+// cursors and tail ids stay valid once lines are inserted again.
+func (db *MemoryDB) remove(token string, buf *buffer) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if buf.n > 0 || db.buffers[token] != buf {
+		return
+	}
+	buf.removed = true
+	delete(db.buffers, token)
+	db.seqs[token] = buf.seq
+}
+
+// Close stops the sweeper and ends every subscription. The buffered lines
+// remain readable, eg by Snapshot.
+func (db *MemoryDB) Close() error {
+	db.closed.Do(func() {
+		close(db.done)
+		db.hub.Close()
+	})
+	return nil
+}
+
 // List lists the stored in memory logs
 func (db *MemoryDB) List(token string) ([]string, error) {
-	buf, ok := db.rings[token]
+	db.mu.RLock()
+	buf, ok := db.buffers[token]
+	db.mu.RUnlock()
+	if !ok {
+		return nil, ErrNoSuchToken
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	buf.expireAge(db.now())
+	return buf.lines(), nil
+}
+
+// Query walks the ring buffer for the page of logs selected by q.
+func (db *MemoryDB) Query(token string, q Query) (*Page, error) {
+	db.mu.RLock()
+	buf, ok := db.buffers[token]
+	db.mu.RUnlock()
 	if !ok {
 		return nil, ErrNoSuchToken
 	}
 
-	lines := make([]string, 0, buf.Len())
-	buf.Do(func(x interface{}) {
-		if line, ok := x.(string); ok {
-			lines = append(lines, line)
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	buf.expireAge(db.now())
+	if !q.Filter.IsZero() {
+		return q.page(buf.lines(), buf.seq), nil
+	}
+
+	last := buf.seq
+	first := last - int64(buf.n) + 1
+	lo, hi := q.bounds(first, last)
+
+	p := &Page{Next: q.next(first, last, lo, hi), Last: last}
+	if lo > hi {
+		return p, nil
+	}
+
+	p.Lines = make([]string, 0, hi-lo+1)
+	if q.Order == NewestFirst {
+		r := buf.head.Move(int((hi - 1) % int64(buf.keep)))
+		for s := hi; s >= lo; s, r = s-1, r.Prev() {
+			p.Lines = append(p.Lines, r.Value.(entry).line)
 		}
-	})
-	return lines, nil
+	} else {
+		r := buf.head.Move(int((lo - 1) % int64(buf.keep)))
+		for s := lo; s <= hi; s, r = s+1, r.Next() {
+			p.Lines = append(p.Lines, r.Value.(entry).line)
+		}
+	}
+	return p, nil
+}
+
+// expireAge evicts lines past their MaxAge at now, so reads between sweeps
+// don't return them. The caller must hold b.mu.
+func (b *buffer) expireAge(now time.Time) {
+	if b.retention.MaxAge > 0 {
+		b.expire(now.Add(-b.retention.MaxAge))
+	}
+}
+
+// lines returns the buffered lines, oldest first. The caller must hold b.mu.
+func (b *buffer) lines() []string {
+	lines := make([]string, 0, b.n)
+	for i, r := 0, b.oldest; i < b.n; i, r = i+1, r.Next() {
+		lines = append(lines, r.Value.(entry).line)
+	}
+	return lines
+}
+
+// Sizes returns the number of lines buffered for every token.
+func (db *MemoryDB) Sizes() (map[string]int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sizes := make(map[string]int, len(db.buffers))
+	for token, buf := range db.buffers {
+		buf.mu.Lock()
+		sizes[token] = buf.n
+		buf.mu.Unlock()
+	}
+	return sizes, nil
+}
+
+// Subscribe follows lines as they are inserted for token.
+func (db *MemoryDB) Subscribe(token string) (*Subscription, error) {
+	return db.hub.Subscribe(token), nil
+}
+
+// buffer returns the buffer for token, creating it if needed. A buffer
+// replacing one removed by the sweeper continues its sequence numbers.
+func (db *MemoryDB) buffer(token string) *buffer {
+	db.mu.RLock()
+	buf, ok := db.buffers[token]
+	db.mu.RUnlock()
+	if ok {
+		return buf
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if buf, ok = db.buffers[token]; !ok {
+		retention := db.policy.For(token)
+		seq := db.seqs[token]
+		r := ring.New(retention.MaxLines)
+		buf = &buffer{
+			retention: retention,
+			keep:      retention.MaxLines,
+			r:         r,
+			head:      r.Move(-int(seq % int64(retention.MaxLines))),
+			oldest:    r,
+			seq:       seq,
+		}
+		db.buffers[token] = buf
+		delete(db.seqs, token)
+	}
+	return buf
 }
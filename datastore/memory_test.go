@@ -0,0 +1,216 @@
+package datastore_test
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/heroku/log-boom/datastore"
+	"github.com/heroku/log-boom/datastore/datastoretest"
+)
+
+func TestMemoryDB(t *testing.T) {
+	datastoretest.Run(t, func(keep int) (ds.Datastore, error) {
+		return ds.NewInMemory(keep)
+	})
+}
+
+// TestMemoryDBParallel inserts into and reads from several tokens at once; run
+// it with -race. Each token has a single writer, so its lines must always be
+// consecutive.
+func TestMemoryDBParallel(t *testing.T) {
+	const (
+		keep    = 50
+		tokens  = 4
+		inserts = 200
+	)
+	db, err := ds.NewInMemory(keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < tokens; i++ {
+		token := fmt.Sprintf("t.%d", i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < inserts; n++ {
+				batch := []string{strconv.Itoa(3 * n), strconv.Itoa(3*n + 1), strconv.Itoa(3*n + 2)}
+				if _, err := db.Insert(token, batch); err != nil {
+					t.Errorf("Insert(%s): %v", token, err)
+					return
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < inserts; n++ {
+				lines, err := db.List(token)
+				if err != nil && err != ds.ErrNoSuchToken {
+					t.Errorf("List(%s): %v", token, err)
+					return
+				}
+				checkConsecutive(t, token, lines, keep)
+
+				page, err := db.Query(token, ds.Query{Order: ds.NewestFirst, Limit: 10})
+				if err != nil && err != ds.ErrNoSuchToken {
+					t.Errorf("Query(%s): %v", token, err)
+					return
+				}
+				if page != nil {
+					for l, r := 0, len(page.Lines)-1; l < r; l, r = l+1, r-1 {
+						page.Lines[l], page.Lines[r] = page.Lines[r], page.Lines[l]
+					}
+					checkConsecutive(t, token, page.Lines, 10)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < tokens; i++ {
+		token := fmt.Sprintf("t.%d", i)
+		lines, err := db.List(token)
+		if err != nil {
+			t.Fatalf("List(%s): %v", token, err)
+		}
+		if len(lines) != keep || lines[keep-1] != strconv.Itoa(3*inserts-1) {
+			t.Errorf("List(%s) = %d lines ending %q, want %d ending %d", token, len(lines), lines[len(lines)-1], keep, 3*inserts-1)
+		}
+	}
+}
+
+func checkConsecutive(t *testing.T, token string, lines []string, max int) {
+	if len(lines) > max {
+		t.Errorf("%s: %d lines, want at most %d", token, len(lines), max)
+	}
+	for i := 1; i < len(lines); i++ {
+		prev, _ := strconv.Atoi(lines[i-1])
+		if cur, err := strconv.Atoi(lines[i]); err != nil || cur != prev+1 {
+			t.Errorf("%s: %q follows %q", token, lines[i], lines[i-1])
+			return
+		}
+	}
+}
+
+// clock is a time source for tests, only moved by advance.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestMemoryDBMaxAge(t *testing.T) {
+	db, err := ds.NewInMemoryWithPolicy(ds.Policy{
+		Default: ds.Retention{MaxLines: 10, MaxAge: time.Minute},
+		Tokens:  map[string]ds.Retention{"short": {MaxAge: 10 * time.Second}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newClock()
+	db.SetClock(c.Now)
+
+	mustInsert(t, db, "t", "1")
+	mustInsert(t, db, "short", "1")
+	c.advance(40 * time.Second)
+	mustInsert(t, db, "t", "2", "3")
+	mustInsert(t, db, "short", "2")
+	c.advance(30 * time.Second)
+
+	// Reads expire lines between sweeps.
+	checkList(t, db, "t", []string{"2", "3"})
+	checkList(t, db, "short", []string{})
+
+	db.Sweep()
+	sizes, err := db.Sizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"t": 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Sizes after sweep = %v, want %v", sizes, want)
+	}
+}
+
+func TestMemoryDBMaxBytes(t *testing.T) {
+	db, err := ds.NewInMemoryWithPolicy(ds.Policy{Default: ds.Retention{MaxLines: 100, MaxBytes: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mustInsert(t, db, "t", "aaaa", "bbbb", "cccc")
+	checkList(t, db, "t", []string{"bbbb", "cccc"})
+	mustInsert(t, db, "t", "dd")
+	checkList(t, db, "t", []string{"bbbb", "cccc", "dd"})
+
+	// A line larger than the limit isn't kept at all.
+	mustInsert(t, db, "t", "eeeeeeeeeeee")
+	checkList(t, db, "t", []string{})
+}
+
+// TestMemoryDBIdle checks the buffers of tokens without lines are removed,
+// and that sequence numbers carry on when lines are inserted again.
+func TestMemoryDBIdle(t *testing.T) {
+	db, err := ds.NewInMemoryWithPolicy(ds.Policy{Default: ds.Retention{MaxLines: 3, MaxAge: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newClock()
+	db.SetClock(c.Now)
+
+	mustInsert(t, db, "t", "1", "2", "3", "4")
+	c.advance(2 * time.Minute)
+	db.Sweep()
+
+	if _, err := db.List("t"); err != ds.ErrNoSuchToken {
+		t.Errorf("List after sweep = %v, want %v", err, ds.ErrNoSuchToken)
+	}
+	if sizes, _ := db.Sizes(); len(sizes) != 0 {
+		t.Errorf("Sizes after sweep = %v, want none", sizes)
+	}
+
+	mustInsert(t, db, "t", "5", "6", "7")
+	page, err := db.Query("t", ds.Query{Cursor: 6})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := []string{"6", "7"}; !reflect.DeepEqual(page.Lines, want) || page.Last != 7 {
+		t.Errorf("Query from 6 = %q, last %d, want %q, last 7", page.Lines, page.Last, want)
+	}
+}
+
+func checkList(t *testing.T, db ds.Datastore, token string, want []string) {
+	lines, err := db.List(token)
+	if err != nil {
+		t.Fatalf("List(%s): %v", token, err)
+	}
+	if len(lines) != len(want) || (len(want) > 0 && !reflect.DeepEqual(lines, want)) {
+		t.Errorf("List(%s) = %q, want %q", token, lines, want)
+	}
+}
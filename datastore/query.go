@@ -0,0 +1,134 @@
+package datastore
+
+// Order is the order a Query returns lines in.
+type Order int
+
+// Orders understood by Query.
+const (
+	OldestFirst Order = iota
+	NewestFirst
+)
+
+// Query selects a page of a token's logs. Every line inserted for a token is
+// numbered in sequence starting at 1; cursors refer to those numbers so that
+// paging is stable while new lines are inserted.
+type Query struct {
+	// Limit is the most lines to return, 0 for no limit.
+	Limit int
+
+	// Cursor is the sequence number of the first line to return, usually the
+	// Next of a previous Page. 0 starts from the oldest or newest line
+	// depending on Order.
+	Cursor int64
+
+	Order Order
+
+	// Filter selects which lines are returned. With a Filter, Limit counts
+	// matching lines and lines are scanned from Cursor until Limit of them
+	// match.
+	Filter Filter
+}
+
+// Page is the result of a Query.
+type Page struct {
+	Lines []string
+
+	// Next is the cursor of the following page, 0 if there are no more lines.
+	Next int64
+
+	// Last is the sequence number of the token's newest line, whether or not
+	// it was selected.
+	Last int64
+}
+
+// bounds returns the inclusive range of sequence numbers q selects from a
+// buffer holding first through last. lo > hi when nothing is selected.
+func (q Query) bounds(first, last int64) (lo, hi int64) {
+	lo, hi = first, last
+
+	if q.Order == NewestFirst {
+		if q.Cursor > 0 && q.Cursor < hi {
+			hi = q.Cursor
+		}
+		if q.Limit > 0 && hi-int64(q.Limit)+1 > lo {
+			lo = hi - int64(q.Limit) + 1
+		}
+		return lo, hi
+	}
+
+	if q.Cursor > lo {
+		lo = q.Cursor
+	}
+	if q.Limit > 0 && lo+int64(q.Limit)-1 < hi {
+		hi = lo + int64(q.Limit) - 1
+	}
+	return lo, hi
+}
+
+// next returns the cursor following a page covering lo through hi.
+func (q Query) next(first, last, lo, hi int64) int64 {
+	if lo > hi {
+		return 0
+	}
+	if q.Order == NewestFirst {
+		if lo > first {
+			return lo - 1
+		}
+		return 0
+	}
+	if hi < last {
+		return hi + 1
+	}
+	return 0
+}
+
+// page applies q to lines, oldest first, the newest of which is numbered last.
+func (q Query) page(lines []string, last int64) *Page {
+	first := last - int64(len(lines)) + 1
+	if !q.Filter.IsZero() {
+		return q.filter(lines, first, last)
+	}
+	lo, hi := q.bounds(first, last)
+
+	p := &Page{Next: q.next(first, last, lo, hi), Last: last}
+	if lo > hi {
+		return p
+	}
+
+	p.Lines = make([]string, 0, hi-lo+1)
+	if q.Order == NewestFirst {
+		for s := hi; s >= lo; s-- {
+			p.Lines = append(p.Lines, lines[s-first])
+		}
+	} else {
+		p.Lines = append(p.Lines, lines[lo-first:hi-first+1]...)
+	}
+	return p
+}
+
+// filter scans lines, numbered first through last, in q's order from its
+// cursor, returning up to Limit lines matching q.Filter.
+func (q Query) filter(lines []string, first, last int64) *Page {
+	s, step := first, int64(1)
+	if q.Cursor > first {
+		s = q.Cursor
+	}
+	if q.Order == NewestFirst {
+		s, step = last, -1
+		if q.Cursor > 0 && q.Cursor < last {
+			s = q.Cursor
+		}
+	}
+
+	p := &Page{Last: last}
+	for ; s >= first && s <= last; s += step {
+		if q.Limit > 0 && len(p.Lines) == q.Limit {
+			p.Next = s
+			break
+		}
+		if line := lines[s-first]; q.Filter.Match(line) {
+			p.Lines = append(p.Lines, line)
+		}
+	}
+	return p
+}
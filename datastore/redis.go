@@ -1,7 +1,12 @@
 package datastore
 
 import (
+	"fmt"
+	"net"
 	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/mediocregopher/radix.v2/pool"
@@ -10,12 +15,39 @@ import (
 
 // RedisDB is the redis implementation of the Datastore interface.
 type RedisDB struct {
-	p    *pool.Pool
-	keep int
+	p      *pool.Pool
+	size   int
+	policy Policy
+	now    func() time.Time
+
+	addr   string
+	secret string // the password of the tail connection, if any
+	hub    *Hub
+	done   chan struct{}
+	closed sync.Once
+
+	mu sync.Mutex
+
+	// tokens are those this instance has inserted lines for, and whether it
+	// has since the last sweep.
+	tokens map[string]bool
+
+	tmu       sync.Mutex
+	tconn     net.Conn                 // the connection subscribed to tail channels, if any
+	following map[string]chan struct{} // tokens followed, and closed once redis confirms
 }
 
-// NewInRedis creates an instance of RedisDB.
+// NewInRedis creates an instance of RedisDB keeping keep lines per token.
 func NewInRedis(u *url.URL, keep, size int) (*RedisDB, error) {
+	return NewInRedisWithPolicy(u, Policy{Default: Retention{MaxLines: keep}}, size)
+}
+
+// NewInRedisWithPolicy creates an instance of RedisDB enforcing the Retention
+// of each token given by p. The lists of tokens are expired by redis once no
+// lines have been inserted for their MaxAge. Lines inserted longer than MaxAge
+// ago, and beyond MaxBytes, are trimmed by a background sweeper from the
+// tokens this instance has inserted lines for.
+func NewInRedisWithPolicy(u *url.URL, p Policy, size int) (*RedisDB, error) {
 
 	client, err := pool.NewCustom("tcp", u.Host, size, dialer(u.User))
 	if err != nil {
@@ -23,8 +55,23 @@ func NewInRedis(u *url.URL, keep, size int) (*RedisDB, error) {
 	}
 
 	db := &RedisDB{
-		p:    client,
-		keep: keep,
+		p:      client,
+		size:   size,
+		policy: p,
+		now:    time.Now,
+		addr:   u.Host,
+		hub:    NewHub(SubscriberBuffer),
+		done:   make(chan struct{}),
+
+		tokens:    make(map[string]bool),
+		following: make(map[string]chan struct{}),
+	}
+	if u.User != nil {
+		db.secret, _ = u.User.Password()
+	}
+	db.hub.idle = db.unfollow
+	if interval, ok := p.sweeps(); ok {
+		go db.sweep(interval)
 	}
 
 	return db, nil
@@ -42,7 +89,25 @@ func (db *RedisDB) Insert(token string, lines []string) (int, error) {
 	}
 	defer db.p.Put(conn)
 
-	if err := conn.Cmd("LPUSH", token, lines).Err; err != nil {
+	var (
+		retention = db.policy.For(token)
+		now       = db.now()
+	)
+
+	conn.PipeAppend("MULTI")
+	conn.PipeAppend("LPUSH", token, lines)
+	conn.PipeAppend("LTRIM", token, 0, retention.MaxLines-1)
+	conn.PipeAppend("INCRBY", seqKey(token), len(lines))
+	conn.PipeAppend("PUBSUB", "NUMSUB", tailChannel+token)
+	if ttl := retention.MaxAge; ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		conn.PipeAppend("PEXPIRE", token, ms)
+		conn.PipeAppend("PEXPIRE", seqKey(token), ms)
+	}
+	conn.PipeAppend("EXEC")
+
+	res, err := exec(conn)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"at":  "Insert",
 			"err": err,
@@ -50,12 +115,25 @@ func (db *RedisDB) Insert(token string, lines []string) (int, error) {
 		return 0, err
 	}
 
-	if err := conn.Cmd("LTRIM", token, 0, db.keep).Err; err != nil {
-		log.WithFields(log.Fields{
-			"at":  "Insert",
-			"err": err,
-		}).Error()
-		return len(lines), err
+	seq, err := res[2].Int64()
+	if err == nil && retention.MaxAge > 0 {
+		if err := db.mark(conn, token, seq, now, retention); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "Insert",
+				"err": err,
+			}).Error("unable to record insert time")
+		}
+	}
+
+	db.mu.Lock()
+	db.tokens[token] = true
+	db.mu.Unlock()
+
+	// Lines are only published if an instance is following the token.
+	if numsub, err := res[3].Array(); err == nil && len(numsub) == 2 {
+		if n, err := numsub[1].Int(); err == nil && n > 0 {
+			db.publish(conn, token, seq, lines)
+		}
 	}
 
 	return len(lines), nil
@@ -70,7 +148,8 @@ func (db *RedisDB) Healthcheck() (bool, error) {
 	return false, err
 }
 
-// List performs LRANGE agains redis.
+// List performs LRANGE agains redis. Lines are pushed to the head of the
+// list, so they are reversed to return them oldest first.
 func (db *RedisDB) List(token string) ([]string, error) {
 	conn, err := db.p.Get()
 	if err != nil {
@@ -95,9 +174,369 @@ func (db *RedisDB) List(token string) ([]string, error) {
 		return nil, err
 	}
 
+	reverse(lines)
 	return lines, nil
 }
 
+// Query performs LRANGE against redis for the page of logs selected by q.
+func (db *RedisDB) Query(token string, q Query) (*Page, error) {
+	conn, err := db.p.Get()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "Query",
+			"err": err,
+		}).Error()
+		return nil, err
+	}
+	defer db.p.Put(conn)
+
+	// Line numbers only move while the list does, so retry should a line be
+	// inserted between reading the newest number and the range.
+	for attempt := 0; ; attempt++ {
+		last, size, err := db.position(conn, token)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, ErrNoSuchToken
+		}
+
+		first := last - size + 1
+		lo, hi := q.bounds(first, last)
+		if !q.Filter.IsZero() {
+			// Which lines match isn't known until they are read, so every
+			// line is, save those drained well before Since.
+			lo, hi = db.drainedSince(conn, token, first, q.Filter.Since), last
+		}
+		p := &Page{Next: q.next(first, last, lo, hi), Last: last}
+		if lo > hi {
+			return p, nil
+		}
+
+		conn.PipeAppend("MULTI")
+		conn.PipeAppend("GET", seqKey(token))
+		conn.PipeAppend("LRANGE", token, last-hi, last-lo)
+		conn.PipeAppend("EXEC")
+		res, err := exec(conn)
+		if err != nil {
+			return nil, err
+		}
+		if seq, _ := res[0].Int64(); seq != last && attempt < 3 {
+			continue
+		}
+
+		// LRANGE returns the lines newest first.
+		if p.Lines, err = res[1].List(); err != nil {
+			return nil, err
+		}
+		if !q.Filter.IsZero() {
+			reverse(p.Lines)
+			return q.page(p.Lines, last), nil
+		}
+		if q.Order == OldestFirst {
+			reverse(p.Lines)
+		}
+		return p, nil
+	}
+}
+
+// position returns the number of the newest line for token and the length
+// of its list.
+func (db *RedisDB) position(conn *redis.Client, token string) (int64, int64, error) {
+	conn.PipeAppend("MULTI")
+	conn.PipeAppend("GET", seqKey(token))
+	conn.PipeAppend("LLEN", token)
+	conn.PipeAppend("EXEC")
+	res, err := exec(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := res[1].Int64()
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := res[0].Int64()
+	if err != nil {
+		// Lists written before line numbering count from their length.
+		last = size
+	}
+	return last, size, nil
+}
+
+// exec reads the replies of a pipelined MULTI ... EXEC, returning the replies
+// to the queued commands.
+func exec(conn *redis.Client) ([]*redis.Resp, error) {
+	var last *redis.Resp
+	for {
+		r := conn.PipeResp()
+		if r.Err == redis.ErrPipelineEmpty {
+			break
+		}
+		if r.Err != nil {
+			conn.PipeClear()
+			return nil, r.Err
+		}
+		last = r
+	}
+	if last == nil {
+		return nil, redis.ErrPipelineEmpty
+	}
+	return last.Array()
+}
+
+// Sizes performs LLEN against redis for every token this instance has
+// inserted logs for.
+func (db *RedisDB) Sizes() (map[string]int, error) {
+	db.mu.Lock()
+	tokens := make([]string, 0, len(db.tokens))
+	for token := range db.tokens {
+		tokens = append(tokens, token)
+	}
+	db.mu.Unlock()
+
+	sizes := make(map[string]int, len(tokens))
+	if len(tokens) == 0 {
+		return sizes, nil
+	}
+
+	conn, err := db.p.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer db.p.Put(conn)
+
+	for _, token := range tokens {
+		conn.PipeAppend("LLEN", token)
+	}
+	for _, token := range tokens {
+		n, err := conn.PipeResp().Int()
+		if err != nil {
+			conn.PipeClear()
+			return nil, err
+		}
+		sizes[token] = n
+	}
+	return sizes, nil
+}
+
+// sweep trims the lists of every token seen by this instance to their
+// MaxAge and MaxBytes every interval.
+func (db *RedisDB) sweep(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-db.done:
+			return
+		}
+
+		db.mu.Lock()
+		tokens := make(map[string]bool, len(db.tokens))
+		for token, inserted := range db.tokens {
+			tokens[token] = inserted
+			db.tokens[token] = false
+		}
+		db.mu.Unlock()
+
+		for token, inserted := range tokens {
+			if err := db.trim(token, inserted); err != nil {
+				log.WithFields(log.Fields{
+					"at":  "sweep",
+					"err": err,
+				}).Error("unable to trim logs")
+			}
+		}
+	}
+}
+
+// mark records that the lines of token up to number seq were inserted at
+// now, so trim can find those older than MaxAge without reading them. Only
+// the newest MaxLines marks can refer to lines still in the list.
+func (db *RedisDB) mark(conn *redis.Client, token string, seq int64, now time.Time, retention Retention) error {
+	ms := int64(retention.MaxAge / time.Millisecond)
+
+	conn.PipeAppend("MULTI")
+	conn.PipeAppend("ZADD", agesKey(token), now.UnixNano()/int64(time.Millisecond), ageMember(seq))
+	conn.PipeAppend("ZREMRANGEBYRANK", agesKey(token), 0, -retention.MaxLines-1)
+	conn.PipeAppend("PEXPIRE", agesKey(token), ms)
+	conn.PipeAppend("EXEC")
+	_, err := exec(conn)
+	return err
+}
+
+// drainSkew is how far ahead of when it was drained a line's syslog
+// timestamp may be and still be found by a Filter with Since.
+const drainSkew = 5 * time.Minute
+
+// drainedSince returns the number of the oldest line of token, from first,
+// which may be timestamped at or after since, going by the insert times
+// recorded by mark. Without a mark old enough, that is first.
+func (db *RedisDB) drainedSince(conn *redis.Client, token string, first int64, since time.Time) int64 {
+	if since.IsZero() {
+		return first
+	}
+
+	cutoff := since.Add(-drainSkew).UnixNano()/int64(time.Millisecond) - 1
+	marks, err := conn.Cmd("ZREVRANGEBYSCORE", agesKey(token), cutoff, "-inf", "LIMIT", 0, 1).List()
+	if err != nil || len(marks) == 0 {
+		return first
+	}
+	seq, err := strconv.ParseInt(marks[0], 10, 64)
+	if err != nil || seq < first {
+		return first
+	}
+	return seq + 1
+}
+
+// trimChunk is the number of lines read at a time when checking MaxBytes.
+const trimChunk = 100
+
+// trim removes the oldest lines of token past its limits, and forgets tokens
+// whose list is gone. Lines past MaxAge are found from the insert times
+// recorded by mark. MaxBytes is only checked if lines were inserted since
+// the last trim, reading no more of the list than the limit.
+func (db *RedisDB) trim(token string, inserted bool) error {
+	retention := db.policy.For(token)
+	if retention.MaxAge == 0 && retention.MaxBytes == 0 {
+		return nil
+	}
+
+	conn, err := db.p.Get()
+	if err != nil {
+		return err
+	}
+	defer db.p.Put(conn)
+
+	last, size, err := db.position(conn, token)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		db.mu.Lock()
+		delete(db.tokens, token)
+		db.mu.Unlock()
+		return nil
+	}
+
+	// Lines numbered up to newest are dropped.
+	first := last - size + 1
+	newest := first - 1
+
+	if retention.MaxAge > 0 {
+		cutoff := db.now().Add(-retention.MaxAge).UnixNano() / int64(time.Millisecond)
+		marks, err := conn.Cmd("ZREVRANGEBYSCORE", agesKey(token), cutoff, "-inf", "LIMIT", 0, 1).List()
+		if err != nil {
+			return err
+		}
+		if len(marks) > 0 {
+			if seq, err := strconv.ParseInt(marks[0], 10, 64); err == nil && seq > newest {
+				newest = seq
+			}
+		}
+		if err := conn.Cmd("ZREMRANGEBYSCORE", agesKey(token), "-inf", cutoff).Err; err != nil {
+			return err
+		}
+	}
+
+	if retention.MaxBytes > 0 && inserted {
+		fit, err := fitting(conn, token, retention.MaxBytes)
+		if err != nil {
+			return err
+		}
+		if seq := last - fit; seq > newest {
+			newest = seq
+		}
+	}
+
+	drop := newest - first + 1
+	if drop <= 0 {
+		return nil
+	}
+
+	// Trim from the tail, so lines pushed since reading the position are
+	// unaffected.
+	return conn.Cmd("LTRIM", token, 0, -drop-1).Err
+}
+
+// fitting returns how many of the newest lines of token fit within max
+// bytes, reading the list a chunk at a time until they no longer do.
+func fitting(conn *redis.Client, token string, max int) (int64, error) {
+	var (
+		bytes int
+		n     int64
+	)
+	for start := int64(0); ; start += trimChunk {
+		// LRANGE returns the lines newest first.
+		lines, err := conn.Cmd("LRANGE", token, start, start+trimChunk-1).List()
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range lines {
+			if bytes += len(line); bytes > max {
+				return n, nil
+			}
+			n++
+		}
+		if len(lines) < trimChunk {
+			return n, nil
+		}
+	}
+}
+
+// PoolAvail returns the number of idle connections in the pool.
+func (db *RedisDB) PoolAvail() int {
+	return db.p.Avail()
+}
+
+// PoolSize returns the configured size of the pool.
+func (db *RedisDB) PoolSize() int {
+	return db.size
+}
+
+// Close stops the sweeper and the tail connection, ends every Subscription
+// and closes the connections in the pool.
+func (db *RedisDB) Close() error {
+	db.closed.Do(func() {
+		close(db.done)
+		db.tmu.Lock()
+		if db.tconn != nil {
+			db.tconn.Close()
+			db.tconn = nil
+		}
+		db.tmu.Unlock()
+
+		db.hub.Close()
+		db.p.Empty()
+	})
+	return nil
+}
+
+func reverse(lines []string) {
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+}
+
+// seqKey holds the number of the newest line inserted for token.
+func seqKey(token string) string {
+	return "log-boom:seq:" + token
+}
+
+// agesKey is the sorted set of the numbers of lines inserted for token,
+// scored by when they were inserted in milliseconds.
+func agesKey(token string) string {
+	return "log-boom:at:" + token
+}
+
+// ageMember pads line numbers so that they sort in order among marks
+// inserted in the same millisecond.
+func ageMember(seq int64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
 func dialer(user *url.Userinfo) pool.DialFunc {
 	if user == nil {
 		return redis.Dial
@@ -0,0 +1,218 @@
+package datastore_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	ds "github.com/heroku/log-boom/datastore"
+	"github.com/heroku/log-boom/datastore/datastoretest"
+)
+
+func TestRedisDB(t *testing.T) {
+	var servers []*datastoretest.RedisServer
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+
+	datastoretest.Run(t, func(keep int) (ds.Datastore, error) {
+		s, err := datastoretest.NewRedisServer()
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+		return ds.NewInRedis(s.URL(), keep, 2)
+	})
+}
+
+// TestRedisDBMaxAge checks lines are trimmed by when they were inserted, not
+// by their syslog timestamp.
+func TestRedisDBMaxAge(t *testing.T) {
+	s, err := datastoretest.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db, err := ds.NewInRedisWithPolicy(s.URL(), ds.Policy{Default: ds.Retention{MaxLines: 100, MaxAge: time.Second}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newClock()
+	db.SetClock(c.Now)
+
+	const (
+		skewed = "<14>1 2001-01-01T00:00:00Z host app - - - from a skewed clock"
+		future = "<14>1 2101-01-01T00:00:00Z host app - - - from the future"
+	)
+	mustInsert(t, db, "t", future)
+	c.advance(600 * time.Millisecond)
+	mustInsert(t, db, "t", skewed, skewed)
+	c.advance(500 * time.Millisecond)
+
+	if err := db.Trim("t"); err != nil {
+		t.Fatalf("Trim: %v", err)
+	}
+	lines, err := db.List("t")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != skewed {
+		t.Errorf("List = %q, want the two lines inserted within MaxAge", lines)
+	}
+}
+
+func TestRedisDBMaxBytes(t *testing.T) {
+	s, err := datastoretest.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db, err := ds.NewInRedisWithPolicy(s.URL(), ds.Policy{Default: ds.Retention{MaxLines: 1000, MaxBytes: 250}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// More lines than are read at a time, so the limit spans several reads.
+	lines := make([]string, 500)
+	for i := range lines {
+		lines[i] = strconv.Itoa(i % 10)
+	}
+	mustInsert(t, db, "t", lines...)
+
+	if err := db.Trim("t"); err != nil {
+		t.Fatalf("Trim: %v", err)
+	}
+	got, err := db.List("t")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 250 || got[0] != "0" {
+		t.Errorf("List = %d lines starting %q, want 250 starting \"0\"", len(got), got[0])
+	}
+}
+
+// TestRedisDBSince checks filtering by Since skips lines drained well before
+// it, going by the insert times kept for MaxAge.
+func TestRedisDBSince(t *testing.T) {
+	s, err := datastoretest.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db, err := ds.NewInRedisWithPolicy(s.URL(), ds.Policy{Default: ds.Retention{MaxLines: 100, MaxAge: 24 * time.Hour}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newClock()
+	db.SetClock(c.Now)
+
+	since := c.Now().Add(time.Hour)
+	line := func(ts time.Time, msg string) string {
+		return "<14>1 " + ts.Format(time.RFC3339) + " host app - - - " + msg
+	}
+	var (
+		skipped = line(since, "drained an hour before it was sent")
+		late    = line(since, "drained a minute before it was sent")
+		current = line(since.Add(time.Minute), "drained when it was sent")
+	)
+	mustInsert(t, db, "t", skipped)
+	c.advance(time.Hour - time.Minute)
+	mustInsert(t, db, "t", late)
+	c.advance(2 * time.Minute)
+	mustInsert(t, db, "t", current)
+
+	p, err := db.Query("t", ds.Query{Filter: ds.Filter{Since: since}})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := []string{late, current}; !reflect.DeepEqual(p.Lines, want) {
+		t.Errorf("Lines = %q, want %q", p.Lines, want)
+	}
+	if p.Next != 0 || p.Last != 3 {
+		t.Errorf("Next = %d, Last = %d, want 0 and 3", p.Next, p.Last)
+	}
+}
+
+// TestRedisDBFollow checks lines are published between instances only while
+// a token is followed, and that each instance subscribes to a token's channel
+// at most once.
+func TestRedisDBFollow(t *testing.T) {
+	s, err := datastoretest.NewRedisServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	writer, err := ds.NewInRedis(s.URL(), 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	reader, err := ds.NewInRedis(s.URL(), 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	const channel = "log-boom:tail:t"
+	mustInsert(t, writer, "t", "unfollowed")
+	if n := s.Publishes(); n != 0 {
+		t.Errorf("%d publishes before following, want 0", n)
+	}
+
+	sub1, err := reader.Subscribe("t")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	sub2, err := reader.Subscribe("t")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if n := s.Subscribers(channel); n != 1 {
+		t.Errorf("%d subscribers to %s, want 1", n, channel)
+	}
+
+	mustInsert(t, writer, "other", "not followed")
+	mustInsert(t, writer, "t", "followed")
+	for _, sub := range []*ds.Subscription{sub1, sub2} {
+		select {
+		case l := <-sub.C:
+			if l.Text != "followed" || l.Seq != 2 {
+				t.Errorf("received %v, want line 2", l)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no line received")
+		}
+	}
+	if n := s.Publishes(); n != 1 {
+		t.Errorf("%d publishes, want 1", n)
+	}
+
+	sub1.Close()
+	if n := s.Subscribers(channel); n != 1 {
+		t.Errorf("%d subscribers to %s with one subscription left, want 1", n, channel)
+	}
+	sub2.Close()
+	for deadline := time.Now().Add(5 * time.Second); s.Subscribers(channel) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("still subscribed to %s after the last subscription closed", channel)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mustInsert(t, writer, "t", "unfollowed again")
+	if n := s.Publishes(); n != 1 {
+		t.Errorf("%d publishes after unfollowing, want 1", n)
+	}
+}
+
+func mustInsert(t *testing.T, db ds.Datastore, token string, lines ...string) {
+	if _, err := db.Insert(token, lines); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// tailChannel is the prefix of the channels inserted lines are published on.
+const tailChannel = "log-boom:tail:"
+
+// subscribeTimeout is how long Subscribe waits for redis to confirm a new
+// tail channel subscription.
+const subscribeTimeout = 5 * time.Second
+
+var errSubscribeTimeout = errors.New("redis did not confirm the tail subscription in time")
+
+// tailMessage is published on a tail channel for every insert. Last is the
+// sequence number of the final line.
+type tailMessage struct {
+	Last  int64    `json:"last"`
+	Lines []string `json:"lines"`
+}
+
+// Subscribe follows lines as they are inserted for token by any instance
+// sharing this redis. Lines inserted once it returns are not missed.
+func (db *RedisDB) Subscribe(token string) (*Subscription, error) {
+	s := db.hub.Subscribe(token)
+	if err := db.follow(token); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// follow subscribes the tail connection to the channel of token, unless it
+// already is, and waits for redis to confirm it.
+func (db *RedisDB) follow(token string) error {
+	db.tmu.Lock()
+	select {
+	case <-db.done:
+		db.tmu.Unlock()
+		return nil
+	default:
+	}
+
+	ready, ok := db.following[token]
+	if !ok {
+		ready = make(chan struct{})
+		db.following[token] = ready
+
+		var err error
+		if db.tconn == nil {
+			err = db.connect()
+		} else {
+			err = db.command("SUBSCRIBE", tailChannel+token)
+		}
+		if err != nil {
+			delete(db.following, token)
+			db.tmu.Unlock()
+			return err
+		}
+	}
+	db.tmu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-db.done:
+		return nil
+	case <-time.After(subscribeTimeout):
+		return errSubscribeTimeout
+	}
+}
+
+// unfollow unsubscribes the tail connection from the channel of a token
+// which has lost its last Subscription. It is called by the hub.
+func (db *RedisDB) unfollow(token string) {
+	db.tmu.Lock()
+	defer db.tmu.Unlock()
+
+	if _, ok := db.following[token]; !ok {
+		return
+	}
+	delete(db.following, token)
+	if db.tconn != nil {
+		// A failed write is found by listen, which reconnects.
+		db.command("UNSUBSCRIBE", tailChannel+token)
+	}
+}
+
+// connect dials the tail connection and subscribes it to the channel of
+// every token followed. The caller must hold db.tmu.
+func (db *RedisDB) connect() error {
+	conn, err := net.DialTimeout("tcp", db.addr, subscribeTimeout)
+	if err != nil {
+		return err
+	}
+	rr := redis.NewRespReader(conn)
+
+	if db.secret != "" {
+		conn.SetDeadline(time.Now().Add(subscribeTimeout))
+		_, err := redis.NewRespFlattenedStrings([]string{"AUTH", db.secret}).WriteTo(conn)
+		if err == nil {
+			err = rr.Read().Err
+		}
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+
+	db.tconn = conn
+	if len(db.following) > 0 {
+		args := []string{"SUBSCRIBE"}
+		for token := range db.following {
+			args = append(args, tailChannel+token)
+		}
+		if err := db.command(args...); err != nil {
+			conn.Close()
+			db.tconn = nil
+			return err
+		}
+	}
+	go db.listen(conn, rr)
+	return nil
+}
+
+// command writes a command to the tail connection, whose replies are read
+// by listen. The caller must hold db.tmu.
+func (db *RedisDB) command(args ...string) error {
+	db.tconn.SetWriteDeadline(time.Now().Add(subscribeTimeout))
+	_, err := redis.NewRespFlattenedStrings(args).WriteTo(db.tconn)
+	return err
+}
+
+// listen feeds the hub the messages received on conn until it fails.
+func (db *RedisDB) listen(conn net.Conn, rr *redis.RespReader) {
+	for {
+		r := rr.Read()
+		if r.IsType(redis.IOErr) {
+			db.lost(conn, r.Err)
+			return
+		}
+
+		// Replies are [kind, channel, count or payload].
+		parts, err := r.Array()
+		if err != nil || len(parts) != 3 {
+			continue
+		}
+		kind, _ := parts[0].Str()
+		channel, _ := parts[1].Str()
+		token := strings.TrimPrefix(channel, tailChannel)
+
+		switch kind {
+		case "subscribe":
+			db.tmu.Lock()
+			if ready, ok := db.following[token]; ok {
+				select {
+				case <-ready:
+				default:
+					close(ready)
+				}
+			}
+			db.tmu.Unlock()
+		case "message":
+			payload, err := parts[2].Bytes()
+			if err != nil {
+				continue
+			}
+			var msg tailMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				// Instances before line numbering publish the bare lines.
+				if err := json.Unmarshal(payload, &msg.Lines); err != nil {
+					continue
+				}
+			}
+			db.hub.Publish(token, msg.Last, msg.Lines)
+		}
+	}
+}
+
+// lost closes a failed tail connection, reconnecting if any token is still
+// followed and the RedisDB isn't closed.
+func (db *RedisDB) lost(conn net.Conn, err error) {
+	db.tmu.Lock()
+	defer db.tmu.Unlock()
+
+	conn.Close()
+	if db.tconn != conn {
+		return
+	}
+	db.tconn = nil
+
+	select {
+	case <-db.done:
+		return
+	default:
+	}
+	log.WithFields(log.Fields{
+		"at":  "subscribe",
+		"err": err,
+	}).Error("subscription lost, reconnecting")
+	go db.reconnect()
+}
+
+// reconnect redials the tail connection every second until it succeeds, no
+// token is followed any more, or the RedisDB is closed.
+func (db *RedisDB) reconnect() {
+	for {
+		select {
+		case <-time.After(time.Second):
+		case <-db.done:
+			return
+		}
+
+		db.tmu.Lock()
+		if db.tconn != nil || len(db.following) == 0 {
+			db.tmu.Unlock()
+			return
+		}
+		err := db.connect()
+		db.tmu.Unlock()
+		if err == nil {
+			return
+		}
+		log.WithFields(log.Fields{
+			"at":  "subscribe",
+			"err": err,
+		}).Error("could not reconnect")
+	}
+}
+
+// publish sends lines, the last of which is numbered last, to the instances
+// following token.
+func (db *RedisDB) publish(conn *redis.Client, token string, last int64, lines []string) {
+	msg, err := json.Marshal(tailMessage{Last: last, Lines: lines})
+	if err != nil {
+		return
+	}
+	if err := conn.Cmd("PUBLISH", tailChannel+token, msg).Err; err != nil {
+		log.WithFields(log.Fields{
+			"at":  "Insert",
+			"err": err,
+		}).Error("unable to publish lines")
+	}
+}
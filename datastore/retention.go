@@ -0,0 +1,80 @@
+package datastore
+
+import "time"
+
+// Retention limits the lines kept for each token. MaxLines is required, the
+// other limits are only enforced when set.
+type Retention struct {
+	// MaxLines is the most lines kept per token.
+	MaxLines int
+
+	// MaxAge is how long lines are kept after being drained. Tokens without
+	// lines younger than MaxAge are forgotten, although the memory datastore
+	// carries on their sequence numbers if lines are drained again.
+	MaxAge time.Duration
+
+	// MaxBytes is the most bytes of lines kept per token. Lines are evicted
+	// oldest first until the rest fit.
+	MaxBytes int
+}
+
+// sweepInterval returns how often lines are checked against the limits
+// enforced in the background.
+func (r Retention) sweepInterval() time.Duration {
+	d := r.MaxAge / 10
+	switch {
+	case r.MaxAge == 0 || d > time.Minute:
+		return time.Minute
+	case d < 10*time.Second:
+		return 10 * time.Second
+	}
+	return d
+}
+
+// Policy is the Retention of every token, allowing some tokens to override
+// the default.
+type Policy struct {
+	Default Retention
+
+	// Tokens holds the overrides by token. Limits left as zero in an
+	// override are taken from Default.
+	Tokens map[string]Retention
+}
+
+// For returns the Retention of token.
+func (p Policy) For(token string) Retention {
+	r, ok := p.Tokens[token]
+	if !ok {
+		return p.Default
+	}
+
+	if r.MaxLines <= 0 {
+		r.MaxLines = p.Default.MaxLines
+	}
+	if r.MaxAge <= 0 {
+		r.MaxAge = p.Default.MaxAge
+	}
+	if r.MaxBytes <= 0 {
+		r.MaxBytes = p.Default.MaxBytes
+	}
+	return r
+}
+
+// sweeps reports whether any token has limits enforced in the background,
+// and how often they should be checked.
+func (p Policy) sweeps() (time.Duration, bool) {
+	var (
+		interval = p.Default.sweepInterval()
+		sweeps   = p.Default.MaxAge > 0 || p.Default.MaxBytes > 0
+	)
+	for token := range p.Tokens {
+		r := p.For(token)
+		if r.MaxAge > 0 || r.MaxBytes > 0 {
+			sweeps = true
+			if d := r.sweepInterval(); d < interval {
+				interval = d
+			}
+		}
+	}
+	return interval, sweeps
+}
@@ -0,0 +1,433 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// S3Config holds the settings for an S3 compatible bucket.
+type S3Config struct {
+	// Endpoint is the base URL of the object store, eg https://s3.amazonaws.com.
+	Endpoint  *url.URL
+	Bucket    string
+	Region    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	// BatchSize is the number of lines buffered per token before they are
+	// written out as a single object.
+	BatchSize int
+
+	// FlushInterval is the longest lines are buffered before being written
+	// out, regardless of BatchSize.
+	FlushInterval time.Duration
+}
+
+// S3DB is the S3 bucket implementation of the Datastore interface. Lines are
+// buffered per token and written as gzip compressed objects named
+// <prefix><token>/<sequence>-<count>.json.gz, where sequence is the number of
+// the newest line in the object; objects beyond the newest keep lines are
+// pruned after every write.
+type S3DB struct {
+	c     *s3Client
+	keep  int
+	batch int
+	pfx   string
+	hub   *Hub
+
+	done   chan struct{}
+	closed sync.Once
+
+	mu      sync.Mutex
+	pending map[string]*s3Batch
+}
+
+// s3Batch holds a token's lines which have not yet been written out. seq is
+// the number of the newest line already written, loaded from the bucket the
+// first time the token is written to.
+type s3Batch struct {
+	mu     sync.Mutex
+	lines  []string
+	since  time.Time
+	seq    int64
+	loaded bool
+}
+
+// NewInS3 creates an instance of S3DB and starts its background flusher.
+func NewInS3(cfg S3Config, keep int) (*S3DB, error) {
+	if cfg.Endpoint == nil || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: endpoint and bucket are required")
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+
+	db := &S3DB{
+		c: &s3Client{
+			endpoint:  cfg.Endpoint,
+			bucket:    cfg.Bucket,
+			region:    cfg.Region,
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			http:      &http.Client{Timeout: 30 * time.Second},
+		},
+		keep:    keep,
+		batch:   cfg.BatchSize,
+		pfx:     cfg.Prefix,
+		hub:     NewHub(SubscriberBuffer),
+		done:    make(chan struct{}),
+		pending: make(map[string]*s3Batch),
+	}
+
+	if cfg.FlushInterval > 0 {
+		go db.flushEvery(cfg.FlushInterval)
+	}
+
+	return db, nil
+}
+
+// Healthcheck performs a HEAD against the bucket.
+func (db *S3DB) Healthcheck() (bool, error) {
+	if err := db.c.headBucket(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Insert buffers lines for token, writing them to the bucket once the batch
+// size is reached. Lines stay buffered when writing fails, to be written by a
+// later Insert or flush, so Insert only fails if nothing was buffered; while
+// writes fail, only the newest lines which would be kept are held.
+func (db *S3DB) Insert(token string, lines []string) (int, error) {
+	b := db.batchFor(token)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.loaded {
+		keys, err := db.c.list(db.tokenPrefix(token))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":  "Insert",
+				"err": err,
+			}).Error()
+			return 0, err
+		}
+		if len(keys) > 0 {
+			b.seq = objectSeq(keys[len(keys)-1])
+		}
+		b.loaded = true
+	}
+
+	if len(b.lines) == 0 {
+		b.since = time.Now()
+	}
+	b.lines = append(b.lines, lines...)
+	if over := len(b.lines) - db.maxPending(); over > 0 {
+		// The dropped lines keep their sequence numbers, so cursors still
+		// refer to the same lines once the rest are written.
+		b.lines = b.lines[:copy(b.lines, b.lines[over:])]
+		b.seq += int64(over)
+		log.WithFields(log.Fields{
+			"at":      "Insert",
+			"dropped": over,
+		}).Warn("dropping unwritten lines")
+	}
+	db.hub.Publish(token, b.seq+int64(len(b.lines)), lines)
+
+	if len(b.lines) < db.batch {
+		return len(lines), nil
+	}
+
+	// The lines are buffered even if writing them fails, so are not
+	// reported as an error which would have them delivered again.
+	if err := db.flush(token, b); err != nil {
+		log.WithFields(log.Fields{
+			"at":  "Insert",
+			"err": err,
+		}).Error()
+	}
+	return len(lines), nil
+}
+
+// maxPending is the most lines buffered per token: every line which would be
+// kept once written, or a full batch if that is larger.
+func (db *S3DB) maxPending() int {
+	if db.batch > db.keep {
+		return db.batch
+	}
+	return db.keep
+}
+
+// List reads back the newest objects for token, up to keep lines, along with
+// any lines still waiting to be written.
+func (db *S3DB) List(token string) ([]string, error) {
+	lines, _, err := db.snapshot(token)
+	return lines, err
+}
+
+// Query reads back the newest objects for token and selects the page of logs
+// selected by q from them.
+func (db *S3DB) Query(token string, q Query) (*Page, error) {
+	lines, last, err := db.snapshot(token)
+	if err != nil {
+		return nil, err
+	}
+	return q.page(lines, last), nil
+}
+
+// snapshot returns up to keep of the newest lines for token, oldest first,
+// along with the number of the newest line.
+func (db *S3DB) snapshot(token string) ([]string, int64, error) {
+	var (
+		pending []string
+		seq     int64
+		loaded  bool
+	)
+	db.mu.Lock()
+	b, ok := db.pending[token]
+	db.mu.Unlock()
+	if ok {
+		b.mu.Lock()
+		pending = append(pending, b.lines...)
+		seq, loaded = b.seq, b.loaded
+		b.mu.Unlock()
+	}
+
+	keys, err := db.c.list(db.tokenPrefix(token))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":  "List",
+			"err": err,
+		}).Error()
+		return nil, 0, err
+	}
+	if len(keys) == 0 && len(pending) == 0 {
+		return nil, 0, ErrNoSuchToken
+	}
+	if !loaded && len(keys) > 0 {
+		seq = objectSeq(keys[len(keys)-1])
+	}
+
+	var (
+		chunks [][]string
+		total  = len(pending)
+	)
+	for i := len(keys) - 1; i >= 0 && total < db.keep; i-- {
+		lines, err := db.read(keys[i])
+		if e, ok := err.(*s3Error); ok && e.Status == 404 {
+			// Pruned by a concurrent write, as is everything older.
+			break
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":  "List",
+				"err": err,
+			}).Error()
+			return nil, 0, err
+		}
+		chunks = append(chunks, lines)
+		total += len(lines)
+	}
+
+	lines := make([]string, 0, total)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		lines = append(lines, chunks[i]...)
+	}
+	lines = append(lines, pending...)
+
+	if len(lines) > db.keep {
+		lines = lines[len(lines)-db.keep:]
+	}
+	return lines, seq + int64(len(pending)), nil
+}
+
+// Subscribe follows lines as they are inserted for token on this instance.
+func (db *S3DB) Subscribe(token string) (*Subscription, error) {
+	return db.hub.Subscribe(token), nil
+}
+
+func (db *S3DB) batchFor(token string) *s3Batch {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	b, ok := db.pending[token]
+	if !ok {
+		b = &s3Batch{}
+		db.pending[token] = b
+	}
+	return b
+}
+
+// flush writes out b's lines as a new object and prunes old objects. The
+// caller must hold b.mu.
+func (db *S3DB) flush(token string, b *s3Batch) error {
+	if len(b.lines) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b.lines); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	seq := b.seq + int64(len(b.lines))
+	key := fmt.Sprintf("%s%020d-%d.json.gz", db.tokenPrefix(token), seq, len(b.lines))
+	if err := db.c.put(key, buf.Bytes()); err != nil {
+		return err
+	}
+	b.seq = seq
+	b.lines = nil
+
+	return db.prune(token)
+}
+
+// prune deletes every object older than those holding the newest keep lines.
+func (db *S3DB) prune(token string) error {
+	keys, err := db.c.list(db.tokenPrefix(token))
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for i := len(keys) - 1; i >= 0; i-- {
+		if total >= db.keep {
+			if err := db.c.delete(keys[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		total += objectCount(keys[i])
+	}
+	return nil
+}
+
+func (db *S3DB) read(key string) ([]string, error) {
+	body, err := db.c.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// flushEvery writes out any batch that has been pending longer than interval.
+func (db *S3DB) flushEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-db.done:
+			return
+		}
+
+		for token, b := range db.batches() {
+			b.mu.Lock()
+			if len(b.lines) > 0 && time.Since(b.since) >= interval {
+				if err := db.flush(token, b); err != nil {
+					log.WithFields(log.Fields{
+						"at":  "flush",
+						"err": err,
+					}).Error()
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// batches returns the pending batch of every token.
+func (db *S3DB) batches() map[string]*s3Batch {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	batches := make(map[string]*s3Batch, len(db.pending))
+	for token, b := range db.pending {
+		batches[token] = b
+	}
+	return batches
+}
+
+// Close stops the background flusher, writes out every pending batch and ends
+// every Subscription. It returns the first error writing out a batch.
+func (db *S3DB) Close() error {
+	var err error
+	db.closed.Do(func() {
+		close(db.done)
+		for token, b := range db.batches() {
+			b.mu.Lock()
+			if ferr := db.flush(token, b); ferr != nil && err == nil {
+				err = ferr
+			}
+			b.mu.Unlock()
+		}
+		db.hub.Close()
+	})
+	return err
+}
+
+func (db *S3DB) tokenPrefix(token string) string {
+	return db.pfx + token + "/"
+}
+
+// objectSeq extracts the number of the newest line encoded in an object key.
+func objectSeq(key string) int64 {
+	seq, _ := objectName(key)
+	return seq
+}
+
+// objectCount extracts the line count encoded in an object key.
+func objectCount(key string) int {
+	_, n := objectName(key)
+	return n
+}
+
+func objectName(key string) (int64, int) {
+	name := key[strings.LastIndex(key, "/")+1:]
+	name = strings.TrimSuffix(name, ".json.gz")
+
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, 0
+	}
+	seq, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return seq, 0
+	}
+	return seq, n
+}
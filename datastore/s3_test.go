@@ -0,0 +1,78 @@
+package datastore_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	ds "github.com/heroku/log-boom/datastore"
+	"github.com/heroku/log-boom/datastore/datastoretest"
+)
+
+func TestS3DB(t *testing.T) {
+	var servers []*datastoretest.S3Server
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+
+	datastoretest.Run(t, func(keep int) (ds.Datastore, error) {
+		s := datastoretest.NewS3Server()
+		servers = append(servers, s)
+		return ds.NewInS3(ds.S3Config{
+			Endpoint:      s.URL(),
+			Bucket:        "log-boom",
+			Region:        "us-east-1",
+			AccessKey:     "access",
+			SecretKey:     "secret",
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+		}, keep)
+	})
+}
+
+// TestS3DBFailingWrites checks lines are held, but not without bound, while
+// the bucket rejects writes, and written once it accepts them again.
+func TestS3DBFailingWrites(t *testing.T) {
+	s := datastoretest.NewS3Server()
+	defer s.Close()
+	db, err := ds.NewInS3(ds.S3Config{
+		Endpoint:  s.URL(),
+		Bucket:    "log-boom",
+		AccessKey: "access",
+		SecretKey: "secret",
+		BatchSize: 2,
+	}, 5)
+	if err != nil {
+		t.Fatalf("NewInS3: %v", err)
+	}
+	defer db.Close()
+
+	mustInsert(t, db, "token", "1", "2")
+	s.FailWrites(true)
+	for i := 3; i <= 12; i++ {
+		mustInsert(t, db, "token", strconv.Itoa(i))
+	}
+
+	page, err := db.Query("token", ds.Query{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := []string{"8", "9", "10", "11", "12"}; !reflect.DeepEqual(page.Lines, want) {
+		t.Errorf("Query while failing = %q, want the newest 5 %q", page.Lines, want)
+	}
+
+	s.FailWrites(false)
+	mustInsert(t, db, "token", "13")
+	s.FailWrites(true) // everything must have been written by now
+
+	page, err = db.Query("token", ds.Query{Cursor: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := []string{"10", "11", "12", "13"}; !reflect.DeepEqual(page.Lines, want) {
+		t.Errorf("Query from 10 after recovering = %q, want %q", page.Lines, want)
+	}
+}
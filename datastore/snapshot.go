@@ -0,0 +1,105 @@
+package datastore
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int                       `json:"version"`
+	Tokens  map[string]snapshotBuffer `json:"tokens"`
+}
+
+// snapshotBuffer holds a token's buffered lines, oldest first, and the number
+// of lines ever written for it so cursors stay valid across a restore.
+type snapshotBuffer struct {
+	Seq   int64           `json:"seq"`
+	Lines []snapshotEntry `json:"lines"`
+}
+
+type snapshotEntry struct {
+	Line string    `json:"line"`
+	At   time.Time `json:"at"`
+}
+
+// Snapshot writes every buffered line to w as gzip compressed JSON, to be
+// read back by Restore.
+func (db *MemoryDB) Snapshot(w io.Writer) error {
+	snap := snapshot{Version: snapshotVersion, Tokens: make(map[string]snapshotBuffer)}
+
+	db.mu.RLock()
+	for token, buf := range db.buffers {
+		buf.mu.Lock()
+		b := snapshotBuffer{Seq: buf.seq, Lines: make([]snapshotEntry, 0, buf.n)}
+		for i, r := 0, buf.oldest; i < buf.n; i, r = i+1, r.Next() {
+			e := r.Value.(entry)
+			b.Lines = append(b.Lines, snapshotEntry{Line: e.line, At: e.at})
+		}
+		buf.mu.Unlock()
+		snap.Tokens[token] = b
+	}
+	db.mu.RUnlock()
+
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Restore reads a snapshot written by Snapshot, replacing the buffers of the
+// tokens in it. Lines beyond the current Retention of a token are dropped.
+func (db *MemoryDB) Restore(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unknown snapshot version %d", snap.Version)
+	}
+
+	for token, b := range snap.Tokens {
+		db.mu.Lock()
+		if old, ok := db.buffers[token]; ok {
+			old.mu.Lock()
+			old.removed = true
+			old.mu.Unlock()
+			delete(db.buffers, token)
+		}
+		db.mu.Unlock()
+
+		buf := db.buffer(token)
+		buf.mu.Lock()
+
+		// Start writing at the slot of the oldest line, so line s still
+		// lives at head.Move((s-1) % keep).
+		if b.Seq < int64(len(b.Lines)) {
+			b.Seq = int64(len(b.Lines))
+		}
+		first := b.Seq - int64(len(b.Lines)) + 1
+		buf.r = buf.head.Move(int((first - 1) % int64(buf.keep)))
+		buf.oldest = buf.r
+		for _, e := range b.Lines {
+			buf.push(entry{line: e.Line, at: e.At})
+		}
+		buf.seq = b.Seq
+		if max := buf.retention.MaxBytes; max > 0 {
+			for buf.bytes > max && buf.n > 0 {
+				buf.evict()
+			}
+		}
+		buf.expireAge(db.now())
+		buf.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package ingest receives syslog over network listeners, alongside the
+// Logplex HTTPS drain, and hands it to the same insert path.
+package ingest
+
+import (
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/heroku/log-boom/auth"
+	"github.com/heroku/log-boom/syslog"
+)
+
+// TokenSDID is the SD-ID of the structured data element whose token
+// parameter names the buffer a message is stored under, eg
+//
+//	<14>1 2026-10-17T00:00:00Z host app 12 - [log-boom token="t.123"] hello
+//
+// The SD-ID may also carry a private enterprise number, eg "log-boom@32473".
+const TokenSDID = "log-boom"
+
+// Reasons messages are dropped, passed to a server's Drop hook.
+const (
+	DropMalformed = "malformed"
+	DropNoToken   = "no_token"
+	DropForbidden = "forbidden_token"
+	DropInsert    = "insert_failed"
+	DropFraming   = "framing"
+	DropTooLarge  = "too_large"
+	DropOverflow  = "overflow"
+)
+
+// InsertFunc stores lines received for token.
+type InsertFunc func(token string, lines []string) error
+
+// frame is a message along with the address it was received from.
+type frame struct {
+	line string
+	src  net.IP
+}
+
+// sink stores frames under the tokens they resolve to.
+type sink struct {
+	token  string
+	tokens auth.DrainTokens
+	routes *Routes
+	insert InsertFunc
+	drop   func(reason string)
+}
+
+// resolve returns the token of f from, in order, its structured data, the
+// routes or the default token, or the reason it is dropped.
+func (s sink) resolve(f frame) (token, drop string) {
+	m, err := syslog.Parse([]byte(f.line))
+	if err != nil {
+		return "", DropMalformed
+	}
+
+	token, ok := m.SDParam(TokenSDID, "token")
+	if !ok {
+		if token, ok = s.routes.Token(m.AppName, f.src); !ok {
+			token = s.token
+		}
+	}
+	switch {
+	case token == "":
+		return "", DropNoToken
+	case !s.tokens.Allows(token):
+		return "", DropForbidden
+	}
+	return token, ""
+}
+
+// store inserts a batch under the tokens of its frames, keeping the order of
+// the lines of each token.
+func (s sink) store(batch []frame) {
+	var (
+		tokens  []string
+		byToken = make(map[string][]string)
+	)
+	for _, f := range batch {
+		token, reason := s.resolve(f)
+		if reason != "" {
+			s.dropped(reason, 1)
+			continue
+		}
+		if _, ok := byToken[token]; !ok {
+			tokens = append(tokens, token)
+		}
+		byToken[token] = append(byToken[token], f.line)
+	}
+
+	for _, token := range tokens {
+		lines := byToken[token]
+		if err := s.insert(token, lines); err != nil {
+			log.WithFields(log.Fields{
+				"at":  "ingest",
+				"err": err,
+			}).Error("could not store logs")
+			s.dropped(DropInsert, len(lines))
+		}
+	}
+}
+
+func (s sink) dropped(reason string, n int) {
+	if s.drop == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		s.drop(reason)
+	}
+}
+
+// consume stores the frames read from frames until it is closed, in batches
+// of up to size of those waiting, so a slow datastore batches frames up
+// rather than stalling the sender one frame at a time.
+func (s sink) consume(frames <-chan frame, size int) {
+	batch := make([]frame, 0, size)
+	for f := range frames {
+		batch = append(batch[:0], f)
+	fill:
+		for len(batch) < size {
+			select {
+			case f, ok := <-frames:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, f)
+			default:
+				break fill
+			}
+		}
+		s.store(batch)
+	}
+}
@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/heroku/log-boom/auth"
+	"github.com/heroku/log-boom/syslog"
+)
+
+// maxDatagram is the largest UDP payload.
+const maxDatagram = 65535
+
+// PacketServer receives syslog over UDP (RFC5426), one message per datagram.
+// Messages are stored under the token in their structured data, see
+// TokenSDID, or else the token Routes gives for their app name or source
+// address, or else Token.
+type PacketServer struct {
+	// Token is the token of messages not otherwise routed. If empty, such
+	// messages are dropped.
+	Token string
+
+	// Routes maps messages to tokens by app name or source address.
+	Routes *Routes
+
+	// Tokens restricts the tokens messages may be stored under, every token
+	// if empty.
+	Tokens auth.DrainTokens
+
+	// Insert stores the messages received.
+	Insert InsertFunc
+
+	// Drop, if set, is called for every message dropped with the reason.
+	Drop func(reason string)
+
+	// MaxFrameSize is the largest datagram accepted,
+	// syslog.DefaultMaxFrameSize if 0.
+	MaxFrameSize int
+
+	// BatchSize is the largest number of messages inserted at once, and the
+	// number which may wait to be inserted before further datagrams are
+	// dropped. DefaultBatchSize if 0.
+	BatchSize int
+
+	mu     sync.Mutex
+	conns  map[net.PacketConn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// ListenAndServe listens on the UDP address addr and serves datagrams.
+func (s *PacketServer) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve reads datagrams from conn until it fails or the server is closed, in
+// which case it returns nil.
+func (s *PacketServer) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.PacketConn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	var (
+		max       = s.MaxFrameSize
+		batchSize = s.BatchSize
+	)
+	if max <= 0 {
+		max = syslog.DefaultMaxFrameSize
+	}
+	if max > maxDatagram {
+		max = maxDatagram
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	sink := sink{
+		token:  s.Token,
+		tokens: s.Tokens,
+		routes: s.Routes,
+		insert: s.Insert,
+		drop:   s.Drop,
+	}
+	frames := make(chan frame, batchSize)
+	done := make(chan struct{})
+	go func() {
+		sink.consume(frames, batchSize)
+		close(done)
+	}()
+	defer func() {
+		close(frames)
+		<-done
+	}()
+
+	// One byte more than max, so oversized datagrams can be told apart.
+	buf := make([]byte, max+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if n > max {
+			sink.dropped(DropTooLarge, 1)
+			continue
+		}
+
+		f := frame{line: string(trimTrailer(buf[:n]))}
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			f.src = udp.IP
+		}
+		select {
+		case frames <- f:
+		default:
+			sink.dropped(DropOverflow, 1)
+		}
+	}
+}
+
+// Close stops reading datagrams and waits for those read to be inserted.
+func (s *PacketServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *PacketServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// trimTrailer removes the newlines or NULs some senders end datagrams with.
+func trimTrailer(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == '\n' || b[len(b)-1] == 0) {
+		b = b[:len(b)-1]
+	}
+	return b
+}
@@ -0,0 +1,215 @@
+package ingest
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heroku/log-boom/auth"
+)
+
+// recorder records what a server inserts and drops.
+type recorder struct {
+	mu       sync.Mutex
+	inserted map[string][]string
+	drops    map[string]int
+	events   int
+}
+
+func newRecorder() *recorder {
+	return &recorder{inserted: make(map[string][]string), drops: make(map[string]int)}
+}
+
+func (r *recorder) insert(token string, lines []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inserted[token] = append(r.inserted[token], lines...)
+	r.events += len(lines)
+	return nil
+}
+
+func (r *recorder) drop(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drops[reason]++
+	r.events++
+}
+
+// wait waits for n lines to have been inserted or dropped.
+func (r *recorder) wait(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		events := r.events
+		r.mu.Unlock()
+		if events >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d lines inserted or dropped", events, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPacketServer(t *testing.T) {
+	const header = "<14>1 2026-10-17T00:00:00Z host "
+	routes, err := ParseRoutes("web:t.web,127.0.0.0/8:t.local,10.0.0.0/8:t.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrouted, err := ParseRoutes("web:t.web,10.0.0.0/8:t.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		token     string
+		routes    *Routes
+		tokens    string
+		datagrams []string
+		inserted  map[string][]string
+		drops     map[string]int
+	}{
+		{
+			name:   "routes",
+			token:  "t.default",
+			routes: routes,
+			datagrams: []string{
+				header + "web 1 - [log-boom token=\"t.sd\"] own token",
+				header + "web 1 - - by app\n",
+				header + "worker 1 - - by address\x00",
+			},
+			inserted: map[string][]string{
+				"t.sd":    {header + "web 1 - [log-boom token=\"t.sd\"] own token"},
+				"t.web":   {header + "web 1 - - by app"},
+				"t.local": {header + "worker 1 - - by address"},
+			},
+			drops: map[string]int{},
+		},
+		{
+			name:   "fallback",
+			token:  "t.default",
+			routes: unrouted,
+			datagrams: []string{
+				header + "web 1 - - by app",
+				header + "worker 1 - - fallback",
+			},
+			inserted: map[string][]string{
+				"t.web":     {header + "web 1 - - by app"},
+				"t.default": {header + "worker 1 - - fallback"},
+			},
+			drops: map[string]int{},
+		},
+		{
+			name:   "drops",
+			routes: unrouted,
+			tokens: "t.web,t.sd",
+			datagrams: []string{
+				header + "worker 1 - - unrouted",
+				"not syslog",
+				header + "web 1 - - " + strings.Repeat("x", 100),
+				header + "web 1 - [log-boom token=\"t.evil\"] forbidden",
+				header + "web 1 - - kept",
+			},
+			inserted: map[string][]string{
+				"t.web": {header + "web 1 - - kept"},
+			},
+			drops: map[string]int{
+				DropNoToken:   1,
+				DropMalformed: 1,
+				DropTooLarge:  1,
+				DropForbidden: 1,
+			},
+		},
+	} {
+		rec := newRecorder()
+		s := &PacketServer{
+			Token:        tt.token,
+			Routes:       tt.routes,
+			Tokens:       auth.ParseDrainTokens(tt.tokens),
+			Insert:       rec.insert,
+			Drop:         rec.drop,
+			MaxFrameSize: 100,
+		}
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan error, 1)
+		go func() { served <- s.Serve(conn) }()
+
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range tt.datagrams {
+			if _, err := client.Write([]byte(d)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		client.Close()
+
+		rec.wait(t, len(tt.datagrams))
+		s.Close()
+		if err := <-served; err != nil {
+			t.Errorf("%s: Serve = %v", tt.name, err)
+		}
+
+		if !reflect.DeepEqual(rec.inserted, tt.inserted) {
+			t.Errorf("%s: inserted %q, want %q", tt.name, rec.inserted, tt.inserted)
+		}
+		if !reflect.DeepEqual(rec.drops, tt.drops) {
+			t.Errorf("%s: dropped %v, want %v", tt.name, rec.drops, tt.drops)
+		}
+	}
+}
+
+func TestPacketServerClose(t *testing.T) {
+	s := &PacketServer{Token: "t", Insert: newRecorder().insert}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(conn) }()
+
+	// Wait for Serve to be reading, so Close has something to unblock.
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Serve never started")
+		}
+	}
+
+	s.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve = %v, want nil once closed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve still running after Close")
+	}
+
+	// Serving after Close returns at once and closes the conn.
+	conn, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(conn); err != nil {
+		t.Errorf("Serve after Close = %v, want nil", err)
+	}
+	if _, err := conn.WriteTo([]byte("x"), conn.LocalAddr()); err == nil {
+		t.Error("conn still open after Serve returned")
+	}
+}
@@ -0,0 +1,73 @@
+package ingest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Routes maps messages without a token of their own to a token by their app
+// name or the address they were received from.
+type Routes struct {
+	apps map[string]string
+	nets []netRoute
+}
+
+type netRoute struct {
+	net   *net.IPNet
+	token string
+}
+
+// ParseRoutes parses comma separated "source:token" pairs, where source is an
+// IP address, a CIDR block or otherwise an app name, eg
+//
+//	10.0.0.0/8:t.internal,192.168.1.5:t.printer,nginx:t.web
+//
+// App names take precedence over addresses, and addresses are matched in the
+// order given.
+func ParseRoutes(s string) (*Routes, error) {
+	r := &Routes{apps: make(map[string]string)}
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("invalid route %q, expected source:token", pair)
+		}
+		source, token := pair[:i], pair[i+1:]
+
+		if _, n, err := net.ParseCIDR(source); err == nil {
+			r.nets = append(r.nets, netRoute{net: n, token: token})
+		} else if ip := net.ParseIP(source); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			n := &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			r.nets = append(r.nets, netRoute{net: n, token: token})
+		} else {
+			r.apps[source] = token
+		}
+	}
+	return r, nil
+}
+
+// Token returns the token routed to for a message from app received from
+// src. It reports false if there is none, including for nil Routes.
+func (r *Routes) Token(app string, src net.IP) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	if token, ok := r.apps[app]; ok && app != "" {
+		return token, true
+	}
+	if src != nil {
+		for _, route := range r.nets {
+			if route.net.Contains(src) {
+				return route.token, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,54 @@
+package ingest
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	r, err := ParseRoutes("web:t.web,10.0.0.0/8:t.internal,10.1.2.3:t.host,2001:db8::/32:t.v6,::1:t.local,,worker:t.worker")
+	if err != nil {
+		t.Fatalf("ParseRoutes: %v", err)
+	}
+
+	for _, tt := range []struct {
+		app   string
+		src   string
+		token string
+		ok    bool
+	}{
+		// App names take precedence over addresses.
+		{"web", "10.1.2.3", "t.web", true},
+		{"worker", "", "t.worker", true},
+		// Addresses are matched in the order given.
+		{"other", "10.1.2.3", "t.internal", true},
+		{"", "10.9.9.9", "t.internal", true},
+		{"other", "2001:db8::1", "t.v6", true},
+		{"other", "::1", "t.local", true},
+		{"other", "::ffff:10.0.0.1", "t.internal", true},
+		{"other", "192.168.1.1", "", false},
+		{"other", "2001:db9::1", "", false},
+		{"other", "", "", false},
+	} {
+		var src net.IP
+		if tt.src != "" {
+			src = net.ParseIP(tt.src)
+		}
+		token, ok := r.Token(tt.app, src)
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("Token(%q, %s) = %q, %v, want %q, %v", tt.app, tt.src, token, ok, tt.token, tt.ok)
+		}
+	}
+
+	if token, ok := (*Routes)(nil).Token("web", net.ParseIP("10.1.2.3")); token != "" || ok {
+		t.Errorf("nil Routes Token = %q, %v, want none", token, ok)
+	}
+}
+
+func TestParseRoutesErrors(t *testing.T) {
+	for _, s := range []string{"web", ":t.web", "web:", "10.0.0.0/8:t.x,nginx"} {
+		if _, err := ParseRoutes(s); err == nil {
+			t.Errorf("ParseRoutes(%q) succeeded, want an error", s)
+		}
+	}
+}
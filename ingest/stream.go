@@ -0,0 +1,225 @@
+package ingest
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/heroku/log-boom/auth"
+	"github.com/heroku/log-boom/syslog"
+)
+
+// DefaultBatchSize is the default largest number of messages from a
+// connection inserted at once.
+const DefaultBatchSize = 100
+
+// StreamServer receives syslog over TCP (RFC6587) or TLS (RFC5425).
+// Messages are stored under the token in their structured data, see
+// TokenSDID, or else Token.
+type StreamServer struct {
+	// Token is the token of messages without one in their structured data.
+	// If empty, such messages are dropped.
+	Token string
+
+	// Tokens restricts the tokens messages may be stored under, every token
+	// if empty.
+	Tokens auth.DrainTokens
+
+	// Insert stores the messages received.
+	Insert InsertFunc
+
+	// Drop, if set, is called for every message dropped with the reason.
+	Drop func(reason string)
+
+	// Framing is how messages are delimited, octet counted if unset.
+	Framing syslog.Framing
+
+	// MaxFrameSize is the largest frame accepted, syslog.DefaultMaxFrameSize
+	// if 0. Connections sending a larger one are closed.
+	MaxFrameSize int
+
+	// BatchSize is the largest number of messages inserted at once,
+	// DefaultBatchSize if 0. Messages are inserted as soon as they are read,
+	// so batches only fill while Insert is slower than the sender.
+	BatchSize int
+
+	// IdleTimeout closes connections which send nothing for this long, if
+	// set.
+	IdleTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections.
+func (s *StreamServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS listens on the TCP address addr and serves TLS
+// connections using config.
+func (s *StreamServer) ListenAndServeTLS(addr string, config *tls.Config) error {
+	l, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server is closed, in
+// which case it returns nil.
+func (s *StreamServer) Serve(l net.Listener) error {
+	if !s.track(l) {
+		l.Close()
+		return nil
+	}
+	defer s.untrack(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting connections, closes those open and waits for what
+// was read from them to be inserted.
+func (s *StreamServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// track registers a listener or connection, reporting false once closed.
+func (s *StreamServer) track(c interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	switch c := c.(type) {
+	case net.Listener:
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[c] = struct{}{}
+	case net.Conn:
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+	}
+	return true
+}
+
+func (s *StreamServer) untrack(c interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch c := c.(type) {
+	case net.Listener:
+		delete(s.listeners, c)
+	case net.Conn:
+		delete(s.conns, c)
+	}
+}
+
+func (s *StreamServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// handle reads frames from conn in the background while storing those
+// already read.
+func (s *StreamServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	sink := sink{
+		token:  s.Token,
+		tokens: s.Tokens,
+		insert: s.Insert,
+		drop:   s.Drop,
+	}
+	frames := make(chan frame, batchSize)
+	scanner := syslog.NewFramedScanner(idleReader{conn, s.IdleTimeout}, s.MaxFrameSize, s.Framing)
+	go func() {
+		var src net.IP
+		if tcp, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			src = tcp.IP
+		}
+		for scanner.Scan() {
+			frames <- frame{line: scanner.Text(), src: src}
+		}
+		close(frames)
+	}()
+	sink.consume(frames, batchSize)
+
+	if err := scanner.Err(); err != nil && !s.isClosed() {
+		switch err {
+		case syslog.ErrNotRFC6587, syslog.ErrTruncatedFrame:
+			sink.dropped(DropFraming, 1)
+		case syslog.ErrFrameTooLarge:
+			sink.dropped(DropTooLarge, 1)
+		}
+		log.WithFields(log.Fields{
+			"at":     "stream",
+			"remote": conn.RemoteAddr().String(),
+			"err":    err,
+		}).Info("closing connection")
+	}
+}
+
+// idleReader extends the read deadline of a connection before every read.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	if r.timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	return r.conn.Read(p)
+}
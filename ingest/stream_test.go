@@ -0,0 +1,197 @@
+package ingest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serve serves l with s, recording what it inserts. stop closes s and
+// returns the lines inserted for each token.
+func serve(s *StreamServer, l net.Listener) (stop func() map[string][]string) {
+	var (
+		mu     sync.Mutex
+		got    = make(map[string][]string)
+		closed = make(chan struct{})
+	)
+	s.Insert = func(token string, lines []string) error {
+		mu.Lock()
+		got[token] = append(got[token], lines...)
+		mu.Unlock()
+		return nil
+	}
+	go func() {
+		s.Serve(l)
+		close(closed)
+	}()
+	return func() map[string][]string {
+		s.Close()
+		<-closed
+		mu.Lock()
+		defer mu.Unlock()
+		return got
+	}
+}
+
+// send writes lines to conn octet counted, then waits for the server to
+// close it once it has read them.
+func send(t *testing.T, conn net.Conn, lines ...string) {
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(conn, "%d %s", len(line), line); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := conn.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.Read(make([]byte, 1))
+	conn.Close()
+}
+
+func TestStreamServerRouting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := serve(&StreamServer{Token: "default"}, l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		routed     = `<14>1 - host app 1 - [log-boom token="t.1"] routed`
+		enterprise = `<14>1 - host app 1 - [meta x="y"][log-boom@32473 token="t.2"] with an enterprise number`
+		other      = `<14>1 - host app 1 - [other token="t.3"] someone else's element`
+		plain      = `<14>1 - host app 1 - - plain`
+		again      = `<14>1 - host app 1 - [log-boom token="t.1"] routed again`
+	)
+	send(t, conn, routed, enterprise, other, plain, again)
+
+	want := map[string][]string{
+		"t.1":     {routed, again},
+		"t.2":     {enterprise},
+		"default": {other, plain},
+	}
+	if got := stop(); !reflect.DeepEqual(got, want) {
+		t.Errorf("inserted %q, want %q", got, want)
+	}
+}
+
+func TestStreamServerTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := serve(&StreamServer{Token: "default"}, l)
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	var (
+		plain  = `<14>1 - host app 1 - - over tls`
+		routed = `<14>1 - host app 1 - [log-boom token="t.1"] routed over tls`
+	)
+	send(t, conn, plain, routed)
+
+	want := map[string][]string{"default": {plain}, "t.1": {routed}}
+	if got := stop(); !reflect.DeepEqual(got, want) {
+		t.Errorf("inserted %q, want %q", got, want)
+	}
+}
+
+// selfSigned returns a certificate for localhost and a pool trusting it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestStreamServerDrops(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		send string
+		want string
+	}{
+		{"too large", "50 <14>1 - - - - - - 0123456789abcdef", DropTooLarge},
+		{"truncated", "30 <14>1 - - - - - -", DropFraming},
+		{"not octet counted", "<14>1 - - - - - - x\n", DropFraming},
+	} {
+		var (
+			mu      sync.Mutex
+			reasons []string
+			closed  = make(chan struct{})
+		)
+		s := &StreamServer{
+			Token:        "t",
+			Insert:       func(string, []string) error { return nil },
+			MaxFrameSize: 40,
+			Drop: func(reason string) {
+				mu.Lock()
+				reasons = append(reasons, reason)
+				mu.Unlock()
+			},
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			s.Serve(l)
+			close(closed)
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(tt.send))
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.Read(make([]byte, 1)) // until the server closes the connection
+		conn.Close()
+
+		s.Close()
+		<-closed
+		mu.Lock()
+		if len(reasons) != 1 || reasons[0] != tt.want {
+			t.Errorf("%s: dropped %v, want [%s]", tt.name, reasons, tt.want)
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(r *Registry) string {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("requests_total", "Requests, per method and path.", "method", "path")
+	c.Inc("POST", "/logs")
+	c.Add(2.5, "GET", `/list/"quoted"`)
+	c.Inc("GET", "back\\slash\nnewline")
+	c.Inc("POST", "/logs")
+
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{.1, 1}, "op")
+	h.Observe(.05, "write")
+	h.Observe(.5, "write")
+	h.Observe(5, "write")
+	h.Observe(1, "read")
+
+	r.NewGaugeFunc("lines", "Lines, per token.", func() []Sample {
+		return []Sample{
+			{LabelValues: []string{"b"}, Value: 2},
+			{LabelValues: []string{"a"}, Value: math.Inf(1)},
+		}
+	}, "token")
+	r.NewGaugeFunc("pool_size", "Pool size.", func() []Sample {
+		return []Sample{{Value: 8}}
+	})
+
+	want := `# HELP requests_total Requests, per method and path.
+# TYPE requests_total counter
+requests_total{method="GET",path="/list/\"quoted\""} 2.5
+requests_total{method="GET",path="back\\slash\nnewline"} 1
+requests_total{method="POST",path="/logs"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="read",le="0.1"} 0
+latency_seconds_bucket{op="read",le="1"} 1
+latency_seconds_bucket{op="read",le="+Inf"} 1
+latency_seconds_sum{op="read"} 1
+latency_seconds_count{op="read"} 1
+latency_seconds_bucket{op="write",le="0.1"} 1
+latency_seconds_bucket{op="write",le="1"} 2
+latency_seconds_bucket{op="write",le="+Inf"} 3
+latency_seconds_sum{op="write"} 5.55
+latency_seconds_count{op="write"} 3
+# HELP lines Lines, per token.
+# TYPE lines gauge
+lines{token="a"} +Inf
+lines{token="b"} 2
+# HELP pool_size Pool size.
+# TYPE pool_size gauge
+pool_size 8
+`
+	// Served twice to check the order is stable.
+	for i := 0; i < 2; i++ {
+		if got := serve(r); got != want {
+			t.Fatalf("served\n%s\nwant\n%s", got, want)
+		}
+	}
+}
+
+func TestRegistryHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewRegistry().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); got != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("empty registry served %q", w.Body)
+	}
+}
@@ -0,0 +1,98 @@
+package syslog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// userFacility is the facility of lines formatted from entries, "user-level
+// messages" in RFC5424.
+const userFacility = 1
+
+// entryTimeFormat is RFC3339 with the at most 6 fractional digits RFC5424
+// allows.
+const entryTimeFormat = "2006-01-02T15:04:05.999999Z07:00"
+
+// Entry is the structured representation of a stored line used by JSON
+// output. Lines which can't be parsed are represented by their Message alone.
+type Entry struct {
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Host      string     `json:"host,omitempty"`
+	App       string     `json:"app,omitempty"`
+	Proc      string     `json:"proc,omitempty"`
+	Severity  *int       `json:"severity,omitempty"`
+	Message   string     `json:"message"`
+}
+
+// NewEntry parses line into an Entry.
+func NewEntry(line string) Entry {
+	m, err := Parse([]byte(line))
+	if err != nil {
+		return Entry{Message: strings.TrimSuffix(line, "\n")}
+	}
+
+	e := Entry{
+		Host:     m.Hostname,
+		App:      m.AppName,
+		Proc:     m.ProcID,
+		Severity: &m.Severity,
+		Message:  m.Message,
+	}
+	if !m.Timestamp.IsZero() {
+		e.Timestamp = &m.Timestamp
+	}
+	return e
+}
+
+// Line formats e as an RFC5424 line, the representation lines are stored in,
+// with the user facility. Empty header fields are written as the NILVALUE
+// and a nil Severity as Informational. Header fields which aren't valid
+// RFC5424, eg contain spaces, are an error.
+func (e Entry) Line() (string, error) {
+	severity := Informational
+	if e.Severity != nil {
+		severity = *e.Severity
+	}
+	if severity < Emergency || severity > Debug {
+		return "", ErrInvalidSeverity
+	}
+
+	timestamp := nilValue
+	if e.Timestamp != nil {
+		timestamp = e.Timestamp.Format(entryTimeFormat)
+	}
+	host, err := headerField(e.Host, maxHostname, ErrInvalidHostname)
+	if err != nil {
+		return "", err
+	}
+	app, err := headerField(e.App, maxAppName, ErrInvalidAppName)
+	if err != nil {
+		return "", err
+	}
+	proc, err := headerField(e.Proc, maxProcID, ErrInvalidProcID)
+	if err != nil {
+		return "", err
+	}
+
+	pri := userFacility*8 + severity
+	msg := strings.TrimSuffix(e.Message, "\n")
+	return fmt.Sprintf("<%d>1 %s %s %s %s - - %s", pri, timestamp, host, app, proc, msg), nil
+}
+
+// headerField checks v is a valid header field of at most max printable
+// US-ASCII characters, returning the NILVALUE if it is empty.
+func headerField(v string, max int, err error) (string, error) {
+	if v == "" {
+		return nilValue, nil
+	}
+	if len(v) > max {
+		return "", err
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < 33 || v[i] > 126 {
+			return "", err
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,151 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Framing is a method of delimiting syslog messages in a stream, as
+// described in RFC6587 section 3.4.
+type Framing int
+
+// Framing methods
+const (
+	// OctetCounted frames are prefixed by their length, eg "11 <14>1 - - -".
+	OctetCounted Framing = iota
+
+	// NonTransparent frames end with a LF or NUL trailer.
+	NonTransparent
+
+	// DetectFraming tells the framing of each frame by its first byte: a
+	// digit starts an octet count and "<" a non-transparent frame.
+	DetectFraming
+)
+
+// ParseFraming parses "octet-counted", "non-transparent" or "auto".
+func ParseFraming(s string) (Framing, error) {
+	switch s {
+	case "octet-counted":
+		return OctetCounted, nil
+	case "non-transparent":
+		return NonTransparent, nil
+	case "auto":
+		return DetectFraming, nil
+	}
+	return 0, fmt.Errorf("unknown framing %q", s)
+}
+
+func (f Framing) String() string {
+	switch f {
+	case OctetCounted:
+		return "octet-counted"
+	case NonTransparent:
+		return "non-transparent"
+	case DetectFraming:
+		return "auto"
+	}
+	return fmt.Sprintf("Framing(%d)", int(f))
+}
+
+// ScanNonTransparent is a bufio.SplitFunc for non-transparent syslog frames,
+// as described in RFC6587 section 3.4.2. The tokens are the syslog messages
+// without their LF or NUL trailer, or a CR before it. Empty frames are
+// skipped, and a final frame without a trailer is returned at EOF.
+func ScanNonTransparent(data []byte, atEOF bool) (int, []byte, error) {
+	return scanNonTransparent(data, atEOF, 0)
+}
+
+// ScanFrames is a bufio.SplitFunc for a stream mixing octet counted and
+// non-transparent frames, telling them apart by their first byte. Trailers
+// between octet counted frames, which some senders add, are skipped.
+func ScanFrames(data []byte, atEOF bool) (int, []byte, error) {
+	return scanFrames(data, atEOF, 0)
+}
+
+// scanNonTransparent is ScanNonTransparent, failing with ErrFrameTooLarge on
+// frames longer than max bytes if max is above 0. Empty frames are skipped
+// here rather than returned as nil tokens, which bufio.Scanner takes as the
+// end of the stream once the reader is at EOF.
+func scanNonTransparent(data []byte, atEOF bool, max int) (int, []byte, error) {
+	for start := 0; ; {
+		rest := data[start:]
+		if atEOF && len(rest) == 0 {
+			return start, nil, nil
+		}
+
+		end := indexTrailer(rest)
+		if end < 0 {
+			if max > 0 && len(rest) > max {
+				return 0, nil, ErrFrameTooLarge
+			}
+			if !atEOF {
+				// Request more data.
+				return start, nil, nil
+			}
+			end = len(rest)
+		}
+		if max > 0 && end > max {
+			return 0, nil, ErrFrameTooLarge
+		}
+
+		advance := end + 1
+		if advance > len(rest) {
+			advance = len(rest)
+		}
+		frame := bytes.TrimSuffix(rest[:end], []byte{'\r'})
+		if len(frame) > 0 {
+			return start + advance, frame, nil
+		}
+		start += advance
+	}
+}
+
+// scanFrames is ScanFrames, failing with ErrFrameTooLarge on frames longer
+// than max bytes if max is above 0.
+func scanFrames(data []byte, atEOF bool, max int) (int, []byte, error) {
+	i := 0
+	for i < len(data) && isTrailer(data[i]) {
+		i++
+	}
+	if i == len(data) {
+		return i, nil, nil
+	}
+
+	// Skipped trailers are consumed along with the frame following them, as
+	// for scanNonTransparent.
+	var (
+		advance int
+		token   []byte
+		err     error
+	)
+	if data[i] >= '0' && data[i] <= '9' {
+		advance, token, err = scanOctetCounted(data[i:], atEOF, max)
+	} else {
+		advance, token, err = scanNonTransparent(data[i:], atEOF, max)
+	}
+	return i + advance, token, err
+}
+
+// scanOctetCounted is ScanRFC6587, failing with ErrFrameTooLarge as soon as
+// the octet count of a frame longer than max bytes is read, if max is above
+// 0, rather than once the frame has been buffered.
+func scanOctetCounted(data []byte, atEOF bool, max int) (int, []byte, error) {
+	if n, mark, err := octetCount(data); err == nil && mark > 0 && max > 0 && n > max {
+		return 0, nil, ErrFrameTooLarge
+	}
+	return ScanRFC6587(data, atEOF)
+}
+
+func isTrailer(c byte) bool {
+	return c == '\n' || c == 0 || c == '\r'
+}
+
+// indexTrailer returns the index of the first LF or NUL in data, or -1.
+func indexTrailer(data []byte) int {
+	for i, c := range data {
+		if c == '\n' || c == 0 {
+			return i
+		}
+	}
+	return -1
+}
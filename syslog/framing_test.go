@@ -0,0 +1,104 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseFraming(t *testing.T) {
+	for _, f := range []Framing{OctetCounted, NonTransparent, DetectFraming} {
+		got, err := ParseFraming(f.String())
+		if err != nil || got != f {
+			t.Errorf("ParseFraming(%q) = %v, %v, want %v", f.String(), got, err, f)
+		}
+	}
+	for _, s := range []string{"", "octet_counted", "Auto", "lf"} {
+		if _, err := ParseFraming(s); err == nil {
+			t.Errorf("ParseFraming(%q) succeeded, want an error", s)
+		}
+	}
+	if got := Framing(7).String(); got != "Framing(7)" {
+		t.Errorf("Framing(7).String() = %q", got)
+	}
+}
+
+func TestFramedScanner(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		framing Framing
+		body    string
+		want    []string
+		err     error
+	}{
+		{"lf", NonTransparent, "<14>1 a\n<14>1 b\n", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"nul", NonTransparent, "<14>1 a\x00<14>1 b\x00", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"crlf", NonTransparent, "<14>1 a\r\n<14>1 b\r\n", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"empty frames", NonTransparent, "\n\n<14>1 a\n\r\n\n", []string{"<14>1 a"}, nil},
+		{"no trailer at eof", NonTransparent, "<14>1 a\n<14>1 b", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"octet count kept", NonTransparent, "7 <14>1 a\n", []string{"7 <14>1 a"}, nil},
+		{"too large", NonTransparent, "<14>1 a\n<14>1 0123456789abcdef\n", []string{"<14>1 a"}, ErrFrameTooLarge},
+		{"too large without trailer", NonTransparent, "<14>1 0123456789abcdef", nil, ErrFrameTooLarge},
+
+		{"octet counted", OctetCounted, "7 <14>1 a7 <14>1 b", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"octet counted with lf", OctetCounted, "7 <14>1 a\n", []string{"<14>1 a"}, ErrNotRFC6587},
+
+		{"mixed", DetectFraming, "7 <14>1 a<14>1 b\n7 <14>1 c\n\x00<14>1 d", []string{"<14>1 a", "<14>1 b", "<14>1 c", "<14>1 d"}, nil},
+		{"mixed trailers", DetectFraming, "\n7 <14>1 a\n\n<14>1 b", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"mixed trailers in one read", DetectFraming, "3 <1>\n\n<2>", []string{"<1>", "<2>"}, nil},
+		{"mixed crlf", DetectFraming, "7 <14>1 a\r\n<14>1 b\r\n", []string{"<14>1 a", "<14>1 b"}, nil},
+		{"mixed too large counted", DetectFraming, "<14>1 a\n99 <14>1", []string{"<14>1 a"}, ErrFrameTooLarge},
+		{"mixed too large trailered", DetectFraming, "7 <14>1 a<14>1 0123456789abcdef\n", []string{"<14>1 a"}, ErrFrameTooLarge},
+		{"mixed truncated", DetectFraming, "9 <14>1 a", nil, ErrTruncatedFrame},
+	} {
+		// Reading a byte at a time checks frames split across reads, and
+		// reading EOF along with the data checks frames split at its end.
+		for _, reader := range []struct {
+			name string
+			wrap func(io.Reader) io.Reader
+		}{
+			{"whole", func(r io.Reader) io.Reader { return r }},
+			{"byte at a time", iotest.OneByteReader},
+			{"eof with data", iotest.DataErrReader},
+		} {
+			s := NewFramedScanner(reader.wrap(strings.NewReader(tt.body)), 16, tt.framing)
+
+			var got []string
+			for s.Scan() {
+				got = append(got, s.Text())
+			}
+			if !reflect.DeepEqual(got, tt.want) || s.Err() != tt.err {
+				t.Errorf("%s (%s): frames %q, %v, want %q, %v", tt.name, reader.name, got, s.Err(), tt.want, tt.err)
+			}
+		}
+	}
+}
+
+func TestScanNonTransparent(t *testing.T) {
+	s := bufio.NewScanner(strings.NewReader("<14>1 a\r\n\x00<14>1 b"))
+	s.Split(ScanNonTransparent)
+
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if want := []string{"<14>1 a", "<14>1 b"}; !reflect.DeepEqual(got, want) || s.Err() != nil {
+		t.Errorf("ScanNonTransparent = %q, %v, want %q", got, s.Err(), want)
+	}
+}
+
+func TestScanFrames(t *testing.T) {
+	s := bufio.NewScanner(strings.NewReader("7 <14>1 a\n<14>1 b\n7 <14>1 c"))
+	s.Split(ScanFrames)
+
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if want := []string{"<14>1 a", "<14>1 b", "<14>1 c"}; !reflect.DeepEqual(got, want) || s.Err() != nil {
+		t.Errorf("ScanFrames = %q, %v, want %q", got, s.Err(), want)
+	}
+}
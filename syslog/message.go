@@ -0,0 +1,305 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Syslog parsing errors
+var (
+	ErrInvalidPriority       = errors.New("Invalid Syslog Priority")
+	ErrInvalidVersion        = errors.New("Invalid Syslog Version")
+	ErrInvalidTimestamp      = errors.New("Invalid Syslog Timestamp")
+	ErrInvalidHostname       = errors.New("Invalid Syslog Hostname")
+	ErrInvalidAppName        = errors.New("Invalid Syslog App Name")
+	ErrInvalidProcID         = errors.New("Invalid Syslog Proc ID")
+	ErrInvalidMsgID          = errors.New("Invalid Syslog Msg ID")
+	ErrInvalidStructuredData = errors.New("Invalid Syslog Structured Data")
+)
+
+// nilValue is the RFC5424 NILVALUE used for absent header fields.
+const nilValue = "-"
+
+// Header field length limits as defined by RFC5424.
+const (
+	maxHostname = 255
+	maxAppName  = 48
+	maxProcID   = 128
+	maxMsgID    = 32
+)
+
+// Message is a parsed RFC5424 or RFC3164 syslog message. Header fields set to
+// the NILVALUE, or absent from RFC3164 messages, are left as their zero
+// value; RFC3164 messages have a Version of 0.
+type Message struct {
+	Priority       int
+	Facility       int
+	Severity       int
+	Version        int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData string
+	Message        string
+}
+
+// Parse parses a single RFC5424 frame into a Message. An RFC6587 octet count
+// prefix, as found in lines stored by earlier releases, is stripped if
+// present. A single trailing newline is removed from the message body.
+//
+// Frames without an RFC5424 VERSION are parsed as RFC3164 by ParseRFC3164,
+// relative to the current time.
+func Parse(frame []byte) (*Message, error) {
+	data := stripOctetCount(frame)
+	if isRFC3164(data) {
+		return ParseRFC3164(data, time.Now())
+	}
+	p := &parser{data: data}
+	return p.parse()
+}
+
+// stripOctetCount removes a leading "NNN " octet count from the frame.
+func stripOctetCount(frame []byte) []byte {
+	i := 0
+	for ; i < len(frame) && frame[i] >= '0' && frame[i] <= '9'; i++ {
+	}
+	if i > 0 && i < len(frame) && frame[i] == ' ' {
+		return frame[i+1:]
+	}
+	return frame
+}
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) parse() (*Message, error) {
+	m := &Message{}
+
+	pri, err := p.priority()
+	if err != nil {
+		return nil, err
+	}
+	m.Priority = pri
+	m.Facility = pri / 8
+	m.Severity = pri % 8
+
+	if m.Version, err = p.version(); err != nil {
+		return nil, err
+	}
+	if m.Timestamp, err = p.timestamp(); err != nil {
+		return nil, err
+	}
+	if m.Hostname, err = p.field(maxHostname, ErrInvalidHostname); err != nil {
+		return nil, err
+	}
+	if m.AppName, err = p.field(maxAppName, ErrInvalidAppName); err != nil {
+		return nil, err
+	}
+	if m.ProcID, err = p.field(maxProcID, ErrInvalidProcID); err != nil {
+		return nil, err
+	}
+	if m.MsgID, err = p.field(maxMsgID, ErrInvalidMsgID); err != nil {
+		return nil, err
+	}
+	if m.StructuredData, err = p.structuredData(); err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.data) && p.data[p.pos] == ' ' {
+		p.pos++
+	}
+	msg := p.data[p.pos:]
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	m.Message = string(msg)
+
+	return m, nil
+}
+
+// priority parses "<" PRIVAL ">".
+func (p *parser) priority() (int, error) {
+	if p.pos >= len(p.data) || p.data[p.pos] != '<' {
+		return 0, ErrInvalidPriority
+	}
+	end := bytes.IndexByte(p.data[p.pos:], '>')
+	if end < 2 || end > 4 {
+		return 0, ErrInvalidPriority
+	}
+	digits := p.data[p.pos+1 : p.pos+end]
+	if len(digits) > 1 && digits[0] == '0' {
+		return 0, ErrInvalidPriority
+	}
+	pri, err := atoi(digits)
+	if err != nil || pri > 191 {
+		return 0, ErrInvalidPriority
+	}
+	p.pos += end + 1
+	return pri, nil
+}
+
+// version parses the VERSION field and its trailing space.
+func (p *parser) version() (int, error) {
+	tok, ok := p.token()
+	if !ok || len(tok) > 3 || tok[0] == '0' {
+		return 0, ErrInvalidVersion
+	}
+	v, err := atoi(tok)
+	if err != nil {
+		return 0, ErrInvalidVersion
+	}
+	return v, nil
+}
+
+// timestamp parses an RFC3339 TIMESTAMP or the NILVALUE.
+func (p *parser) timestamp() (time.Time, error) {
+	tok, ok := p.token()
+	if !ok {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	if string(tok) == nilValue {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(tok))
+	if err != nil {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	return t, nil
+}
+
+// field parses a space terminated header field of printable US-ASCII.
+func (p *parser) field(max int, perr error) (string, error) {
+	tok, ok := p.token()
+	if !ok || len(tok) > max {
+		return "", perr
+	}
+	for _, c := range tok {
+		if c < 33 || c > 126 {
+			return "", perr
+		}
+	}
+	if string(tok) == nilValue {
+		return "", nil
+	}
+	return string(tok), nil
+}
+
+// structuredData parses the STRUCTURED-DATA field, returning it verbatim.
+// Logplex omits the field entirely, so anything other than the NILVALUE or
+// well formed SD-ELEMENTs followed by a space is treated as the start of the
+// message, as are elements without parameters whose SD-ID isn't registered:
+// tagged logs such as "[req-abc] Started GET" are far more common than those.
+func (p *parser) structuredData() (string, error) {
+	if p.pos >= len(p.data) {
+		return "", nil
+	}
+	if p.data[p.pos] == '-' && (p.pos+1 == len(p.data) || p.data[p.pos+1] == ' ') {
+		p.pos++
+		return "", nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] == '[' {
+		n, ok := sdElement(p.data[p.pos:])
+		if !ok {
+			break
+		}
+		p.pos += n
+	}
+	if p.pos == start || (p.pos < len(p.data) && p.data[p.pos] != ' ') {
+		p.pos = start
+		return "", nil
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+// maxSDName is the longest SD-NAME, ie SD-ID or PARAM-NAME, RFC5424 allows.
+const maxSDName = 32
+
+// registeredSDIDs are the SD-IDs registered with IANA, which may appear
+// without parameters.
+var registeredSDIDs = map[string]bool{"timeQuality": true, "origin": true, "meta": true}
+
+// sdElement returns the length of the SD-ELEMENT data starts with, reporting
+// false if it isn't one, or is one without parameters and an unregistered
+// SD-ID.
+func sdElement(data []byte) (int, bool) {
+	i := 1
+	id := sdName(data[i:])
+	if id == 0 {
+		return 0, false
+	}
+	i += id
+
+	params := 0
+	for i < len(data) {
+		switch data[i] {
+		case ']':
+			ok := params > 0 || registeredSDIDs[string(data[1:1+id])]
+			return i + 1, ok
+		case ' ':
+			i++
+		default:
+			return 0, false
+		}
+
+		name := sdName(data[i:])
+		if name == 0 || i+name+1 >= len(data) || data[i+name] != '=' || data[i+name+1] != '"' {
+			return 0, false
+		}
+		i += name + 2
+		for ; i < len(data) && data[i] != '"'; i++ {
+			if data[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(data) {
+			return 0, false
+		}
+		i++
+		params++
+	}
+	return 0, false
+}
+
+// sdName returns the length of the SD-NAME data starts with, or 0 if it
+// doesn't start with one.
+func sdName(data []byte) int {
+	i := 0
+	for ; i < len(data) && i <= maxSDName; i++ {
+		c := data[i]
+		if c < 33 || c > 126 || c == '=' || c == ' ' || c == ']' || c == '"' {
+			break
+		}
+	}
+	if i > maxSDName {
+		return 0
+	}
+	return i
+}
+
+// token returns the bytes up to the next space and advances past it.
+func (p *parser) token() ([]byte, bool) {
+	end := bytes.IndexByte(p.data[p.pos:], ' ')
+	if end <= 0 {
+		return nil, false
+	}
+	tok := p.data[p.pos : p.pos+end]
+	p.pos += end + 1
+	return tok, true
+}
+
+func atoi(b []byte) (int, error) {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, strconv.ErrSyntax
+		}
+	}
+	return strconv.Atoi(string(b))
+}
@@ -0,0 +1,182 @@
+package syslog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		frame string
+		want  Message
+	}{
+		{
+			"<165>1 2026-10-17T00:00:00.123456+02:00 host.example.com app web.1 ID47 - hello world\n",
+			Message{
+				Priority: 165, Facility: 20, Severity: 5, Version: 1,
+				Timestamp: time.Date(2026, 10, 16, 22, 0, 0, 123456000, time.UTC),
+				Hostname:  "host.example.com", AppName: "app", ProcID: "web.1", MsgID: "ID47",
+				Message: "hello world",
+			},
+		},
+		{"<0>1 - - - - - -", Message{Version: 1}},
+		{"<191>999 - - - - - -", Message{Priority: 191, Facility: 23, Severity: 7, Version: 999}},
+		{"<14>1 - - - - - msg\n\n", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Message: "msg\n"}},
+
+		// The NILVALUE leaves each header field empty.
+		{"<14>1 - h a p m - x", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Hostname: "h", AppName: "a", ProcID: "p", MsgID: "m", Message: "x"}},
+		{"<14>1 2026-10-17T00:00:00Z - a p m - x", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Timestamp: date(2026, 10, 17), AppName: "a", ProcID: "p", MsgID: "m", Message: "x"}},
+		{"<14>1 2026-10-17T00:00:00Z h - p m - x", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Timestamp: date(2026, 10, 17), Hostname: "h", ProcID: "p", MsgID: "m", Message: "x"}},
+		{"<14>1 2026-10-17T00:00:00Z h a - m - x", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Timestamp: date(2026, 10, 17), Hostname: "h", AppName: "a", MsgID: "m", Message: "x"}},
+		{"<14>1 2026-10-17T00:00:00Z h a p - - x", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Timestamp: date(2026, 10, 17), Hostname: "h", AppName: "a", ProcID: "p", Message: "x"}},
+		{"<14>1 2026-10-17T00:00:00Z h a p m -", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Timestamp: date(2026, 10, 17), Hostname: "h", AppName: "a", ProcID: "p", MsgID: "m"}},
+		// As does leaving out STRUCTURED-DATA and the message, as Logplex may.
+		{"<14>1 - h a p m ", Message{Priority: 14, Facility: 1, Severity: 6, Version: 1, Hostname: "h", AppName: "a", ProcID: "p", MsgID: "m"}},
+
+		// The longest header fields allowed.
+		{
+			"<14>1 - " + strings.Repeat("h", 255) + " " + strings.Repeat("a", 48) + " " + strings.Repeat("p", 128) + " " + strings.Repeat("m", 32) + " - x",
+			Message{
+				Priority: 14, Facility: 1, Severity: 6, Version: 1,
+				Hostname: strings.Repeat("h", 255), AppName: strings.Repeat("a", 48), ProcID: strings.Repeat("p", 128), MsgID: strings.Repeat("m", 32),
+				Message: "x",
+			},
+		},
+	} {
+		m, err := Parse([]byte(tt.frame))
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.frame, err)
+			continue
+		}
+		if !m.Timestamp.Equal(tt.want.Timestamp) {
+			t.Errorf("Parse(%q) Timestamp = %v, want %v", tt.frame, m.Timestamp, tt.want.Timestamp)
+		}
+		m.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(*m, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.frame, *m, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const fields = " - host app 12 ID - msg"
+
+	for _, tt := range []struct {
+		frame string
+		err   error
+	}{
+		{"", ErrInvalidPriority},
+		{"14>1" + fields, ErrInvalidPriority},
+		{"<14" + fields, ErrInvalidPriority},
+		{"<>1" + fields, ErrInvalidPriority},
+		{"<192>1" + fields, ErrInvalidPriority},
+		{"<999>1" + fields, ErrInvalidPriority},
+		{"<1000>1" + fields, ErrInvalidPriority},
+		{"<014>1" + fields, ErrInvalidPriority},
+		{"<1a>1" + fields, ErrInvalidPriority},
+		{"<-1>1" + fields, ErrInvalidPriority},
+
+		{"<14>0" + fields, ErrInvalidVersion},
+		{"<14>01" + fields, ErrInvalidVersion},
+		{"<14>1000" + fields, ErrInvalidVersion},
+
+		{"<14>1 2026-13-01T00:00:00Z host app 12 ID - msg", ErrInvalidTimestamp},
+		{"<14>1 2026-10-17T00:00:00 host app 12 ID - msg", ErrInvalidTimestamp},
+		{"<14>1 2026-10-17 host app 12 ID - msg", ErrInvalidTimestamp},
+		{"<14>1 Oct 17 00:00:00 host app 12 ID - msg", ErrInvalidTimestamp},
+		{"<14>1 -- host app 12 ID - msg", ErrInvalidTimestamp},
+
+		{"<14>1 - " + strings.Repeat("h", 256) + " app 12 ID - msg", ErrInvalidHostname},
+		{"<14>1 - h\x01st app 12 ID - msg", ErrInvalidHostname},
+		{"<14>1 - h\xc3\xa9st app 12 ID - msg", ErrInvalidHostname},
+		{"<14>1 -  app 12 ID - msg", ErrInvalidHostname},
+
+		{"<14>1 - host " + strings.Repeat("a", 49) + " 12 ID - msg", ErrInvalidAppName},
+		{"<14>1 - host a\x7fp 12 ID - msg", ErrInvalidAppName},
+
+		{"<14>1 - host app " + strings.Repeat("1", 129) + " ID - msg", ErrInvalidProcID},
+		{"<14>1 - host app 1\t2 ID - msg", ErrInvalidProcID},
+
+		{"<14>1 - host app 12 " + strings.Repeat("I", 33) + " - msg", ErrInvalidMsgID},
+		{"<14>1 - host app 12 I\x00D - msg", ErrInvalidMsgID},
+
+		// Headers cut short fail on the first missing field.
+		{"<14>1 ", ErrInvalidTimestamp},
+		{"<14>1 -", ErrInvalidTimestamp},
+		{"<14>1 - host", ErrInvalidHostname},
+		{"<14>1 - host app", ErrInvalidAppName},
+		{"<14>1 - host app 12", ErrInvalidProcID},
+		{"<14>1 - host app 12 ID", ErrInvalidMsgID},
+	} {
+		if _, err := Parse([]byte(tt.frame)); err != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.frame, err, tt.err)
+		}
+	}
+}
+
+func TestParseOctetCount(t *testing.T) {
+	for _, tt := range []struct {
+		frame   string
+		appName string
+		msg     string
+		err     error
+	}{
+		{"<14>1 - host app - - - hi", "app", "hi", nil},
+		{"26 <14>1 - host app - - - hi", "app", "hi", nil},
+		// The count isn't checked against the length.
+		{"3 <14>1 - host app - - - hi", "app", "hi", nil},
+		{"24 <34>Oct 11 22:14:15 host su: hi", "su", "hi", nil},
+		// Only a count followed by a space is stripped, and only one.
+		{"26<14>1 - host app - - - hi", "", "", ErrInvalidPriority},
+		{"1 2 <14>1 - host app - - - hi", "", "", ErrInvalidPriority},
+		// Digits starting the message are kept.
+		{"<14>1 - host app - - - 200 OK", "app", "200 OK", nil},
+	} {
+		m, err := Parse([]byte(tt.frame))
+		if err != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.frame, err, tt.err)
+			continue
+		}
+		if err == nil && (m.AppName != tt.appName || m.Message != tt.msg) {
+			t.Errorf("Parse(%q) = AppName %q, Message %q, want %q, %q", tt.frame, m.AppName, m.Message, tt.appName, tt.msg)
+		}
+	}
+}
+
+func TestParseStructuredData(t *testing.T) {
+	const header = "<190>1 2026-10-17T00:00:00+00:00 host app web.1 - "
+
+	for _, tt := range []struct {
+		rest string
+		sd   string
+		msg  string
+	}{
+		// Logplex omits STRUCTURED-DATA, so tags are part of the message.
+		{"[req-abc] Started GET", "", "[req-abc] Started GET"},
+		{"[req-abc]Completed 200", "", "[req-abc]Completed 200"},
+		{"[req-abc] [user 5] Started GET", "", "[req-abc] [user 5] Started GET"},
+		{"[a b=c] unquoted", "", "[a b=c] unquoted"},
+		{"[unterminated x=\"y\" oops", "", "[unterminated x=\"y\" oops"},
+		{"at=info method=GET", "", "at=info method=GET"},
+
+		{"- hello", "", "hello"},
+		{"[log-boom token=\"t.123\"] hello", "[log-boom token=\"t.123\"]", "hello"},
+		{"[a@32473 x=\"1\" y=\"q\\\"]\"][b@1 z=\"\"] hi", "[a@32473 x=\"1\" y=\"q\\\"]\"][b@1 z=\"\"]", "hi"},
+		{"[timeQuality] hi", "[timeQuality]", "hi"},
+		{"[origin ip=\"10.0.0.1\"]", "[origin ip=\"10.0.0.1\"]", ""},
+	} {
+		m, err := Parse([]byte(header + tt.rest))
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rest, err)
+			continue
+		}
+		if m.StructuredData != tt.sd || m.Message != tt.msg {
+			t.Errorf("Parse(%q) = SD %q, Message %q, want %q, %q", tt.rest, m.StructuredData, m.Message, tt.sd, tt.msg)
+		}
+		if m.ProcID != "web.1" {
+			t.Errorf("Parse(%q) ProcID = %q, want web.1", tt.rest, m.ProcID)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package syslog
+
+import (
+	"bytes"
+	"time"
+)
+
+// maxTag bounds RFC3164 tags, which become the AppName. The RFC limits
+// them to 32 characters, but many senders exceed it.
+const maxTag = maxAppName
+
+// futureSkew is how far ahead of the reference time an RFC3164 timestamp
+// may be before it is assumed to be from the year before, eg a December
+// message read in January.
+const futureSkew = 31 * 24 * time.Hour
+
+// isRFC3164 reports whether a frame starting with a priority is in the BSD
+// syslog format, ie is not followed by an RFC5424 VERSION.
+func isRFC3164(data []byte) bool {
+	end := bytes.IndexByte(data, '>')
+	if end < 0 {
+		return false
+	}
+	rest := data[end+1:]
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	return i == 0 || i >= len(rest) || rest[i] != ' '
+}
+
+// ParseRFC3164 parses a single RFC3164 (BSD syslog) frame into a Message:
+//
+//	<34>Oct 11 22:14:15 mymachine su[231]: 'su root' failed for lonvick
+//
+// The TAG and its optional PID become the AppName and ProcID, and Version is
+// 0. Timestamps carry no year or zone, so are taken as UTC in the year which
+// puts them nearest before ref, allowing for some clock skew. RFC3339
+// timestamps, as sent by rsyslog and others, are also accepted. The
+// HOSTNAME is optional, as many senders leave it out.
+func ParseRFC3164(frame []byte, ref time.Time) (*Message, error) {
+	p := &parser{data: stripOctetCount(frame)}
+
+	m := &Message{}
+	pri, err := p.priority()
+	if err != nil {
+		return nil, err
+	}
+	m.Priority = pri
+	m.Facility = pri / 8
+	m.Severity = pri % 8
+
+	if m.Timestamp, err = p.bsdTimestamp(ref); err != nil {
+		return nil, err
+	}
+
+	// The HOSTNAME is absent if the first word is already the TAG.
+	if tok, ok := p.peek(); ok {
+		if _, _, isTag := splitTag(tok); !isTag {
+			if len(tok) > maxHostname {
+				return nil, ErrInvalidHostname
+			}
+			m.Hostname = string(tok)
+			p.pos += len(tok)
+			p.skipSpaces()
+		}
+	}
+	if tok, ok := p.peek(); ok {
+		if tag, pid, isTag := splitTag(tok); isTag {
+			m.AppName, m.ProcID = string(tag), string(pid)
+			p.pos += len(tok)
+			p.skipSpaces()
+		}
+	}
+
+	msg := p.data[p.pos:]
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	m.Message = string(msg)
+	return m, nil
+}
+
+// bsdTimestamp parses a "Mmm dd hh:mm:ss" TIMESTAMP, or an RFC3339 one, and
+// the spaces following it.
+func (p *parser) bsdTimestamp(ref time.Time) (time.Time, error) {
+	if tok, ok := p.peek(); ok && len(tok) > 0 && tok[0] >= '0' && tok[0] <= '9' {
+		t, err := time.Parse(time.RFC3339Nano, string(tok))
+		if err != nil {
+			return time.Time{}, ErrInvalidTimestamp
+		}
+		p.pos += len(tok)
+		p.skipSpaces()
+		return t, nil
+	}
+
+	// Month, day and time are separated by single spaces, but days below
+	// 10 are padded with a second one.
+	start := p.pos
+	for field := 0; field < 3; field++ {
+		p.skipSpaces()
+		tok, ok := p.peek()
+		if !ok {
+			return time.Time{}, ErrInvalidTimestamp
+		}
+		p.pos += len(tok)
+	}
+	t, err := time.Parse(time.Stamp, string(p.data[start:p.pos]))
+	if err != nil {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	p.skipSpaces()
+
+	// Go back from the reference year to the latest one in which the date
+	// exists and is not too far ahead, so Feb 29 lands in a leap year
+	// rather than becoming Mar 1.
+	ref = ref.UTC()
+	latest := ref.Add(futureSkew)
+	for year := ref.Year(); ; year-- {
+		d := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		if d.Day() == t.Day() && !d.After(latest) {
+			return d, nil
+		}
+	}
+}
+
+// peek returns the bytes up to the next space, or the end of the frame,
+// without advancing.
+func (p *parser) peek() ([]byte, bool) {
+	rest := p.data[p.pos:]
+	end := bytes.IndexByte(rest, ' ')
+	if end < 0 {
+		end = len(rest)
+	}
+	if end == 0 {
+		return nil, false
+	}
+	return rest[:end], true
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.data) && p.data[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// splitTag splits a "tag:" or "tag[pid]:" word into its tag and pid,
+// reporting whether it is one.
+func splitTag(word []byte) (tag, pid []byte, ok bool) {
+	n := len(word)
+	if n < 2 || word[n-1] != ':' {
+		return nil, nil, false
+	}
+	tag = word[:n-1]
+	if i := bytes.IndexByte(tag, '['); i >= 0 {
+		if tag[len(tag)-1] != ']' {
+			return nil, nil, false
+		}
+		tag, pid = tag[:i], tag[i+1:len(tag)-1]
+		if len(pid) > maxProcID {
+			return nil, nil, false
+		}
+	}
+	if len(tag) == 0 || len(tag) > maxTag || bytes.ContainsAny(tag, "[]:") {
+		return nil, nil, false
+	}
+	return tag, pid, true
+}
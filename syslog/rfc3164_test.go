@@ -0,0 +1,79 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRFC3164Timestamp(t *testing.T) {
+	for _, tt := range []struct {
+		stamp string
+		ref   time.Time
+		want  time.Time
+	}{
+		// A December message read in January is from the year before.
+		{"Dec 31 23:59:59", date(2027, 1, 1), time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC)},
+		// Clocks running somewhat ahead keep the current year.
+		{"Nov  1 00:00:00", date(2026, 10, 17), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"Oct 17 03:00:00", date(2026, 10, 17), time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)},
+		{"Nov 20 03:00:00", date(2026, 10, 17), time.Date(2025, 11, 20, 3, 0, 0, 0, time.UTC)},
+		// Feb 29 goes back to the latest leap year, rather than Mar 1.
+		{"Feb 29 12:00:00", date(2027, 3, 1), time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"Feb 29 12:00:00", date(2028, 3, 1), time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"Feb 28 12:00:00", date(2027, 3, 1), time.Date(2027, 2, 28, 12, 0, 0, 0, time.UTC)},
+		// RFC3339 timestamps carry their own year and zone.
+		{"2020-02-29T12:00:00.5+01:00", date(2027, 1, 1), time.Date(2020, 2, 29, 11, 0, 0, 5e8, time.UTC)},
+	} {
+		m, err := ParseRFC3164([]byte("<13>"+tt.stamp+" host app: hi"), tt.ref)
+		if err != nil {
+			t.Errorf("ParseRFC3164(%q): %v", tt.stamp, err)
+			continue
+		}
+		if !m.Timestamp.Equal(tt.want) {
+			t.Errorf("ParseRFC3164(%q) at %v = %v, want %v", tt.stamp, tt.ref, m.Timestamp, tt.want)
+		}
+	}
+
+	for _, stamp := range []string{"Feb 30 12:00:00", "Foo  1 00:00:00", "Oct 17", "2026-10-17"} {
+		if _, err := ParseRFC3164([]byte("<13>"+stamp+" host app: hi"), date(2026, 10, 17)); err != ErrInvalidTimestamp {
+			t.Errorf("ParseRFC3164(%q) error = %v, want %v", stamp, err, ErrInvalidTimestamp)
+		}
+	}
+}
+
+func TestParseRFC3164Tag(t *testing.T) {
+	for _, tt := range []struct {
+		rest     string
+		hostname string
+		appName  string
+		procID   string
+		msg      string
+	}{
+		{"mymachine su[231]: 'su root' failed", "mymachine", "su", "231", "'su root' failed"},
+		{"mymachine cron: job done\n", "mymachine", "cron", "", "job done"},
+		{"su[231]: no hostname", "", "su", "231", "no hostname"},
+		{"cron: no hostname", "", "cron", "", "no hostname"},
+		{"mymachine no tag here", "mymachine", "", "", "no tag here"},
+		{"mymachine su[231: unterminated", "mymachine", "", "", "su[231: unterminated"},
+		{"mymachine su[]: empty pid", "mymachine", "su", "", "empty pid"},
+		{"mymachine :", "mymachine", "", "", ":"},
+	} {
+		m, err := ParseRFC3164([]byte("<34>Oct 11 22:14:15 "+tt.rest), date(2026, 10, 17))
+		if err != nil {
+			t.Errorf("ParseRFC3164(%q): %v", tt.rest, err)
+			continue
+		}
+		if m.Hostname != tt.hostname || m.AppName != tt.appName || m.ProcID != tt.procID || m.Message != tt.msg {
+			t.Errorf("ParseRFC3164(%q) = %q %q %q %q, want %q %q %q %q", tt.rest,
+				m.Hostname, m.AppName, m.ProcID, m.Message,
+				tt.hostname, tt.appName, tt.procID, tt.msg)
+		}
+		if m.Facility != 4 || m.Severity != 2 || m.Version != 0 {
+			t.Errorf("ParseRFC3164(%q) = facility %d, severity %d, version %d", tt.rest, m.Facility, m.Severity, m.Version)
+		}
+	}
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,150 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Router parsing errors
+var (
+	ErrNotRouter     = errors.New("Not A Heroku Router Message")
+	ErrInvalidLogfmt = errors.New("Invalid Logfmt")
+)
+
+// RouterLine is a parsed Heroku router message, as logged for every request
+// with app name "heroku" and proc id "router". Absent fields are left as their
+// zero value.
+type RouterLine struct {
+	At        string // info, warning or error
+	Code      string // the error code of failed requests, eg "H12"
+	Desc      string
+	Method    string
+	Path      string
+	Host      string
+	RequestID string
+	Fwd       string
+	Dyno      string
+	Connect   time.Duration
+	Service   time.Duration
+	Status    int
+	Bytes     int
+	Protocol  string
+
+	// HasConnect and HasService report whether Connect and Service were
+	// logged and valid, as a zero duration is too.
+	HasConnect bool
+	HasService bool
+}
+
+// IsRouter reports whether m was logged by the Heroku router.
+func IsRouter(m *Message) bool {
+	return m.AppName == "heroku" && m.ProcID == "router"
+}
+
+// ParseRouter parses the message body of a Heroku router line, eg
+// "at=info method=GET path=/ status=200 connect=1ms service=20ms".
+func ParseRouter(msg string) (*RouterLine, error) {
+	fields, err := ParseLogfmt(msg)
+	if err != nil {
+		return nil, err
+	}
+	if fields["at"] == "" || fields["path"] == "" {
+		return nil, ErrNotRouter
+	}
+
+	l := &RouterLine{
+		At:        fields["at"],
+		Code:      fields["code"],
+		Desc:      fields["desc"],
+		Method:    fields["method"],
+		Path:      fields["path"],
+		Host:      fields["host"],
+		RequestID: fields["request_id"],
+		Fwd:       fields["fwd"],
+		Dyno:      fields["dyno"],
+		Protocol:  fields["protocol"],
+	}
+	// Numeric fields are best effort; the router logs them as absent or
+	// non-numeric for requests which never reached a dyno.
+	l.Connect, err = time.ParseDuration(fields["connect"])
+	l.HasConnect = err == nil
+	l.Service, err = time.ParseDuration(fields["service"])
+	l.HasService = err == nil
+	l.Status, _ = strconv.Atoi(fields["status"])
+	l.Bytes, _ = strconv.Atoi(fields["bytes"])
+	return l, nil
+}
+
+// ParseLogfmt parses space separated key=value pairs. Values may be double
+// quoted, with backslash escapes. Keys without a value are set to "".
+func ParseLogfmt(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	for i := 0; i < len(s); {
+		if s[i] == ' ' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' {
+			i++
+		}
+		key := s[start:i]
+		if key == "" || key[0] == '"' {
+			return nil, ErrInvalidLogfmt
+		}
+		if i == len(s) || s[i] == ' ' {
+			fields[key] = ""
+			continue
+		}
+		i++ // skip '='
+
+		if i < len(s) && s[i] == '"' {
+			value, n, err := unquote(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			fields[key] = value
+			i += n
+			continue
+		}
+
+		start = i
+		for i < len(s) && s[i] != ' ' {
+			i++
+		}
+		fields[key] = s[start:i]
+	}
+	return fields, nil
+}
+
+// unquote reads a double quoted value from the start of s, returning it and
+// the number of bytes consumed.
+func unquote(s string) (string, int, error) {
+	var b bytes.Buffer
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", 0, ErrInvalidLogfmt
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, ErrInvalidLogfmt
+}
@@ -0,0 +1,48 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRouter(t *testing.T) {
+	for _, tt := range []struct {
+		msg        string
+		want       RouterLine
+		hasConnect bool
+		hasService bool
+	}{
+		{
+			`at=info method=GET path="/users?page=2" host=app.herokuapp.com request_id=abc fwd="1.2.3.4" dyno=web.1 connect=0ms service=12ms status=200 bytes=512 protocol=https`,
+			RouterLine{At: "info", Method: "GET", Path: "/users?page=2", Host: "app.herokuapp.com", RequestID: "abc", Fwd: "1.2.3.4", Dyno: "web.1", Service: 12 * time.Millisecond, Status: 200, Bytes: 512, Protocol: "https"},
+			true, true,
+		},
+		{
+			`at=error code=H12 desc="Request timeout" method=GET path="/" dyno=web.1 connect=1ms service=30000ms status=503 bytes=0`,
+			RouterLine{At: "error", Code: "H12", Desc: "Request timeout", Method: "GET", Path: "/", Dyno: "web.1", Connect: time.Millisecond, Service: 30 * time.Second, Status: 503},
+			true, true,
+		},
+		{
+			// Requests which never reached a dyno log no timings.
+			`at=error code=H10 desc="App crashed" method=GET path="/" dyno= connect= service= status=503 bytes=`,
+			RouterLine{At: "error", Code: "H10", Desc: "App crashed", Method: "GET", Path: "/", Status: 503},
+			false, false,
+		},
+	} {
+		got, err := ParseRouter(tt.msg)
+		if err != nil {
+			t.Errorf("ParseRouter(%q): %v", tt.msg, err)
+			continue
+		}
+		tt.want.HasConnect, tt.want.HasService = tt.hasConnect, tt.hasService
+		if *got != tt.want {
+			t.Errorf("ParseRouter(%q) = %+v, want %+v", tt.msg, *got, tt.want)
+		}
+	}
+
+	for _, msg := range []string{"Starting process with command `bin/web`", "at=info", `at="unterminated`} {
+		if _, err := ParseRouter(msg); err == nil {
+			t.Errorf("ParseRouter(%q) succeeded, want an error", msg)
+		}
+	}
+}
@@ -4,51 +4,179 @@ import (
 	"bufio"
 	"errors"
 	"io"
-	"strconv"
+	"math"
 )
 
+// DefaultMaxFrameSize is the default largest syslog frame, excluding its
+// octet count, that a Scanner accepts.
+const DefaultMaxFrameSize = 64 * 1024
+
+// maxOctetCountDigits bounds the length of an octet count, so a stream of
+// digits can't be buffered indefinitely or overflow.
+const maxOctetCountDigits = 9
+
 // Syslog scanning errors
 var (
-	ErrNotRFC6587 = errors.New("Not RFC6587 Formatted Syslog")
+	ErrNotRFC6587     = errors.New("Not RFC6587 Formatted Syslog")
+	ErrTruncatedFrame = errors.New("Syslog Frame Shorter Than Its Octet Count")
+	ErrFrameTooLarge  = errors.New("Syslog Frame Larger Than The Maximum Frame Size")
 )
 
-// ScanRFC6587 does stuff
+// ScanRFC6587 is a bufio.SplitFunc for octet counted syslog frames, as
+// described in RFC6587 section 3.4.1. The tokens are the syslog messages
+// without the "NNN " octet count.
 func ScanRFC6587(data []byte, atEOF bool) (int, []byte, error) {
-	mark := 0
-	for ; mark < len(data); mark++ {
-		if data[mark] == ' ' {
-			break
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	length, mark, err := octetCount(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if mark < 0 {
+		if atEOF {
+			return 0, nil, ErrNotRFC6587
 		}
+		// Request more data.
+		return 0, nil, nil
 	}
 
-	for i := mark; i < len(data); i++ {
-		if data[i] == '<' {
-			offset, err := strconv.Atoi(string(data[0:mark]))
-			if err != nil {
-				return 0, nil, err
+	end := mark + 1 + length
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, ErrTruncatedFrame
+		}
+		// Request more data.
+		return 0, nil, nil
+	}
+	if data[mark+1] != '<' {
+		return 0, nil, ErrNotRFC6587
+	}
+	return end, data[mark+1 : end], nil
+}
+
+// octetCount reads the "NNN " prefix of a frame, returning the count and the
+// index of the space. mark is -1 if data ends before the space.
+func octetCount(data []byte) (n, mark int, err error) {
+	for i, c := range data {
+		switch {
+		case c == ' ' && i > 0:
+			if n == 0 {
+				return 0, 0, ErrNotRFC6587
 			}
-			token := data[:mark+offset+1]
-			return len(token), token, nil
+			return n, i, nil
+		case c < '0' || c > '9' || i == maxOctetCountDigits:
+			return 0, 0, ErrNotRFC6587
 		}
+		n = n*10 + int(c-'0')
 	}
+	return n, -1, nil
+}
 
-	if atEOF && len(data) > mark {
-		return 0, nil, ErrNotRFC6587
+// Scanner reads RFC6587 frames from a stream one at a time, holding no more
+// than a single frame in memory.
+type Scanner struct {
+	s       *bufio.Scanner
+	max     int
+	framing Framing
+
+	// buf and ends are reused by ReadBatch to collect a batch of frames.
+	buf  []byte
+	ends []int
+}
+
+// NewScanner returns a Scanner reading octet counted frames from r which
+// fails with ErrFrameTooLarge on frames larger than maxFrame bytes. A
+// maxFrame of 0 or less means DefaultMaxFrameSize.
+func NewScanner(r io.Reader, maxFrame int) *Scanner {
+	return NewFramedScanner(r, maxFrame, OctetCounted)
+}
+
+// NewFramedScanner is like NewScanner, but reads frames delimited by framing.
+func NewFramedScanner(r io.Reader, maxFrame int, framing Framing) *Scanner {
+	if maxFrame <= 0 {
+		maxFrame = DefaultMaxFrameSize
+	}
+
+	s := &Scanner{s: bufio.NewScanner(r), max: maxFrame, framing: framing}
+	initial := 4096
+	if initial > maxFrame {
+		initial = maxFrame
+	}
+	s.s.Buffer(make([]byte, 0, initial), maxFrame+maxOctetCountDigits+1)
+	s.s.Split(s.split)
+	return s
+}
+
+// split rejects oversized frames as soon as their octet count or first
+// s.max bytes are read, rather than once they have been buffered.
+func (s *Scanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	switch s.framing {
+	case NonTransparent:
+		return scanNonTransparent(data, atEOF, s.max)
+	case DetectFraming:
+		return scanFrames(data, atEOF, s.max)
+	}
+	return scanOctetCounted(data, atEOF, s.max)
+}
+
+// Scan advances to the next frame, returning false at the end of the stream
+// or on an error.
+func (s *Scanner) Scan() bool {
+	return s.s.Scan()
+}
+
+// Bytes returns the current frame. The slice is only valid until the next
+// call to Scan.
+func (s *Scanner) Bytes() []byte {
+	return s.s.Bytes()
+}
+
+// Text returns a copy of the current frame.
+func (s *Scanner) Text() string {
+	return s.s.Text()
+}
+
+// ReadBatch appends up to n frames to lines and returns the extended slice.
+// The frames of a batch share a single allocation. Fewer than n frames are
+// only appended at the end of the stream or on an error, reported by Err.
+func (s *Scanner) ReadBatch(lines []string, n int) []string {
+	s.buf, s.ends = s.buf[:0], s.ends[:0]
+	if cap(s.ends) < n && n <= 1024 {
+		s.ends = make([]int, 0, n)
+	}
+	for len(s.ends) < n && s.s.Scan() {
+		s.buf = append(s.buf, s.s.Bytes()...)
+		s.ends = append(s.ends, len(s.buf))
 	}
 
-	// Request more data.
-	return mark, nil, nil
+	all, start := string(s.buf), 0
+	for _, end := range s.ends {
+		lines = append(lines, all[start:end])
+		start = end
+	}
+	return lines
+}
+
+// Err returns the first error encountered, or nil at the end of the stream.
+func (s *Scanner) Err() error {
+	if err := s.s.Err(); err != bufio.ErrTooLong {
+		return err
+	}
+	return ErrFrameTooLarge
 }
 
-// Scan scans the reader for count RFC6587 formatted syslog entries.
+// Scan scans the reader for count RFC6587 formatted syslog entries. The
+// whole body is held in memory; use a Scanner to stream it instead.
 func Scan(r io.Reader, count int64) ([]string, error) {
-	scanner := bufio.NewScanner(r)
-	scanner.Split(ScanRFC6587)
+	scanner := NewScanner(r, DefaultMaxFrameSize)
 
-	lines := make([]string, 0, count)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	// count comes from the client, so don't trust it for more than a hint.
+	if count < 0 || count > 1024 {
+		count = 1024
 	}
+	lines := scanner.ReadBatch(make([]string, 0, count), math.MaxInt32)
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
@@ -56,3 +184,22 @@ func Scan(r io.Reader, count int64) ([]string, error) {
 
 	return lines, nil
 }
+
+// ScanMessages scans the reader for count RFC6587 formatted syslog entries
+// and parses each of them into a Message.
+func ScanMessages(r io.Reader, count int64) ([]*Message, error) {
+	lines, err := Scan(r, count)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*Message, 0, len(lines))
+	for _, line := range lines {
+		m, err := Parse([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
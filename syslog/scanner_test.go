@@ -0,0 +1,104 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const benchLine = "<190>1 2026-10-17T00:00:00.000000+00:00 host app web.1 - - at=info method=GET path=\"/\" status=200 bytes=1024"
+
+// body returns n octet counted frames of line.
+func body(line string, n int) []byte {
+	frame := fmt.Sprintf("%d %s", len(line), line)
+	return bytes.Repeat([]byte(frame), n)
+}
+
+func TestScan(t *testing.T) {
+	lines, err := Scan(bytes.NewReader(body(benchLine, 3)), 3)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("Scan = %d lines, want 3", len(lines))
+	}
+	for _, line := range lines {
+		if line != benchLine {
+			t.Errorf("Scan line = %q, want %q", line, benchLine)
+		}
+	}
+}
+
+func TestScannerErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		body     string
+		maxFrame int
+		want     error
+	}{
+		{"too large", "12 <14>1 - - - -", 10, ErrFrameTooLarge},
+		{"too large before read", "99999 <14>1", 100, ErrFrameTooLarge},
+		{"truncated", "20 <14>1 - - - -", 0, ErrTruncatedFrame},
+		{"not octet counted", "<14>1 - - - -", 0, ErrNotRFC6587},
+		{"zero count", "0 <14>1", 0, ErrNotRFC6587},
+	} {
+		s := NewScanner(strings.NewReader(tt.body), tt.maxFrame)
+		for s.Scan() {
+		}
+		if err := s.Err(); err != tt.want {
+			t.Errorf("%s: Err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestScannerReadBatch(t *testing.T) {
+	s := NewScanner(bytes.NewReader(body(benchLine, 5)), 0)
+
+	var got []int
+	for {
+		lines := s.ReadBatch(nil, 2)
+		if len(lines) == 0 {
+			break
+		}
+		for _, line := range lines {
+			if line != benchLine {
+				t.Errorf("ReadBatch line = %q, want %q", line, benchLine)
+			}
+		}
+		got = append(got, len(lines))
+	}
+	if fmt.Sprint(got) != "[2 2 1]" {
+		t.Errorf("ReadBatch sizes = %v, want [2 2 1]", got)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	data := body(benchLine, 100)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Scan(bytes.NewReader(data), 100); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScannerReadBatch(b *testing.B) {
+	data := body(benchLine, 100)
+	r := bytes.NewReader(data)
+	lines := make([]string, 0, 100)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Seek(0, io.SeekStart)
+		s := NewScanner(r, 0)
+		if lines = s.ReadBatch(lines[:0], 100); len(lines) != 100 {
+			b.Fatalf("ReadBatch = %d lines, want 100", len(lines))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package syslog
+
+import "bytes"
+
+// SDParam returns the value of the parameter name of the first structured
+// data element of m whose SD-ID is id, or id followed by "@" and a private
+// enterprise number, eg "log-boom@32473".
+func (m *Message) SDParam(id, name string) (string, bool) {
+	sd := m.StructuredData
+	for len(sd) > 0 && sd[0] == '[' {
+		end := elementEnd(sd)
+		if end < 0 {
+			return "", false
+		}
+		element := sd[1:end]
+		sd = sd[end+1:]
+
+		i := 0
+		for i < len(element) && element[i] != ' ' {
+			i++
+		}
+		sdID := element[:i]
+		if sdID != id && !(len(sdID) > len(id) && sdID[:len(id)] == id && sdID[len(id)] == '@') {
+			continue
+		}
+		if value, ok := param(element[i:], name); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// elementEnd returns the index of the "]" closing the SD-ELEMENT sd starts
+// with, or -1.
+func elementEnd(sd string) int {
+	quoted, escaped := false, false
+	for i := 1; i < len(sd); i++ {
+		switch c := sd[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// param finds name="value" among the SD-PARAMs of an element, unescaping
+// the value.
+func param(params, name string) (string, bool) {
+	for len(params) > 0 {
+		for len(params) > 0 && params[0] == ' ' {
+			params = params[1:]
+		}
+		eq := 0
+		for eq < len(params) && params[eq] != '=' {
+			eq++
+		}
+		if eq+1 >= len(params) || params[eq+1] != '"' {
+			return "", false
+		}
+		key := params[:eq]
+
+		var value bytes.Buffer
+		i := eq + 2
+		for ; i < len(params) && params[i] != '"'; i++ {
+			if params[i] == '\\' && i+1 < len(params) {
+				i++
+			}
+			value.WriteByte(params[i])
+		}
+		if key == name {
+			return value.String(), true
+		}
+		if i >= len(params) {
+			return "", false
+		}
+		params = params[i+1:]
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package syslog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Syslog severities as defined by RFC5424.
+const (
+	Emergency = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Informational
+	Debug
+)
+
+// ErrInvalidSeverity is returned by ParseSeverity for unknown severities.
+var ErrInvalidSeverity = errors.New("Invalid Syslog Severity")
+
+// severityNames are the keywords commonly used for each severity, as used by
+// syslog.conf, indexed by severity.
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// SeverityName returns the keyword for severity, eg "err" for Error.
+func SeverityName(severity int) string {
+	if severity < Emergency || severity > Debug {
+		return strconv.Itoa(severity)
+	}
+	return severityNames[severity]
+}
+
+// ParseSeverity parses a severity given either as its number or keyword,
+// case insensitively. "error", "warn" and "panic" are accepted as aliases.
+func ParseSeverity(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < Emergency || n > Debug {
+			return 0, ErrInvalidSeverity
+		}
+		return n, nil
+	}
+
+	s = strings.ToLower(s)
+	switch s {
+	case "panic":
+		return Emergency, nil
+	case "error":
+		return Error, nil
+	case "warn":
+		return Warning, nil
+	}
+	for severity, name := range severityNames {
+		if s == name {
+			return severity, nil
+		}
+	}
+	return 0, ErrInvalidSeverity
+}